@@ -0,0 +1,125 @@
+package main
+
+import (
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"github.com/blaize9/vuze-tools/vuze"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Copies every file of a completed recovery directory into the Azureus directory. The files it replaces are
+// snapshotted first and listed in a manifest so rollback can restore them.
+func Apply(recoveryDir string) RecoveryResult {
+	log.Info("Apply\n-------------------------------")
+	if recoveryDir == "" {
+		recoveryDir = config.GetAzRecoverPath()
+	}
+	files, err := vuze.RecoveryFiles(recoveryDir)
+	if err != nil {
+		log.Fatalf("Unable to read recovery directory %s [%v]", recoveryDir, err)
+	}
+	if len(files) == 0 {
+		log.Infof("%s has nothing to apply", recoveryDir)
+		return RecoveryResult{}
+	}
+
+	// Every apply gets its own directory, even when two run within the same second
+	stamp := time.Now().Format("2006-01-02T150405")
+	snapshotDir := filepath.Join(config.GetAzRollbackPath(), stamp)
+	if !plan.DryRun {
+		if err := os.MkdirAll(config.GetAzRollbackPath(), os.FileMode(0755)); err != nil {
+			log.Fatalf("Unable to create %s [%v]", config.GetAzRollbackPath(), err)
+		}
+		if snapshotDir, err = os.MkdirTemp(config.GetAzRollbackPath(), stamp+"-"); err != nil {
+			log.Fatalf("Unable to create the snapshot directory [%v]", err)
+		}
+	}
+	manifest := vuze.NewApplyManifest(snapshotDir, recoveryDir, config.Get().AzureusDirectory, files)
+
+	// Nothing is replaced unless every snapshot could be taken. The manifest is only written once they all were,
+	// so rollback never picks an apply whose snapshots are missing.
+	for _, file := range manifest.Files {
+		if file.Existed {
+			dest := filepath.Join(manifest.Destination, file.Path)
+			if err := plan.ReplaceFile(dest, file.Snapshot, vuze.ActionSnapshot, "replaced by apply"); err != nil {
+				os.RemoveAll(snapshotDir)
+				log.Fatalf("Unable to snapshot %s [%v]", dest, err)
+			}
+		}
+	}
+	if !plan.DryRun {
+		if err := manifest.Save(); err != nil {
+			os.RemoveAll(snapshotDir)
+			log.Fatalf("Unable to write apply manifest [%v]", err)
+		}
+	}
+
+	recovered := 0
+	unrecoverable := 0
+	for _, file := range manifest.Files {
+		src := filepath.Join(recoveryDir, file.Path)
+		dest := filepath.Join(manifest.Destination, file.Path)
+		if err := plan.ReplaceFile(src, dest, vuze.ActionReplace, "recovered file"); err != nil {
+			unrecoverable++
+			log.Errorf("Unable to apply %s [%v]", dest, err)
+			report.Add(file.Path, vuze.ReportEntry{Filepath: dest, Found: utils.FileExists(dest), Source: "apply", BackupPath: src, State: vuze.StateUnrecoverable, Error: err.Error()})
+			continue
+		}
+		recovered++
+		report.Add(file.Path, vuze.ReportEntry{Filepath: dest, Found: true, Valid: true, Source: "apply", BackupPath: src, State: vuze.StateRecovered})
+	}
+
+	log.Infof("Applied %d of %d files, run rollback to undo [manifest: %s]", recovered, len(files), manifest.Path())
+	return RecoveryResult{Total: len(files), Recovered: recovered, Unrecoverable: unrecoverable}
+}
+
+// Restores the snapshots of an apply and removes the files it added
+func Rollback(manifestPath string) RecoveryResult {
+	log.Info("Rollback\n-------------------------------")
+	var manifest *vuze.ApplyManifest
+	var err error
+	if manifestPath != "" {
+		manifest, err = vuze.LoadApplyManifest(manifestPath)
+	} else {
+		manifest, err = vuze.LatestApplyManifest(config.GetAzRollbackPath())
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Infof("Rolling back the apply of %s from %s", manifest.Applied, manifest.Path())
+
+	recovered := 0
+	unrecoverable := 0
+	for i := len(manifest.Files) - 1; i >= 0; i-- {
+		file := manifest.Files[i]
+		dest := filepath.Join(manifest.Destination, file.Path)
+		if file.Existed {
+			err = plan.ReplaceFile(file.Snapshot, dest, vuze.ActionReplace, "restored snapshot")
+		} else if utils.FileExists(dest) {
+			err = plan.RemoveFile(dest, "added by apply")
+		} else {
+			err = nil
+		}
+		if err != nil {
+			unrecoverable++
+			log.Errorf("Unable to roll back %s [%v]", dest, err)
+			report.Add(file.Path, vuze.ReportEntry{Filepath: dest, Source: "rollback", BackupPath: file.Snapshot, State: vuze.StateUnrecoverable, Error: err.Error()})
+			continue
+		}
+		recovered++
+		report.Add(file.Path, vuze.ReportEntry{Filepath: dest, Found: file.Existed, Valid: file.Existed, Source: "rollback", BackupPath: file.Snapshot, State: vuze.StateRecovered})
+	}
+
+	if !plan.DryRun && unrecoverable == 0 {
+		now := time.Now()
+		manifest.RolledBack = &now
+		if err := manifest.Save(); err != nil {
+			log.Errorf("Unable to mark %s as rolled back [%v]", manifest.Path(), err)
+		}
+	}
+	log.Infof("Rolled back %d of %d files", recovered, len(manifest.Files))
+	return RecoveryResult{Total: len(manifest.Files), Recovered: recovered, Unrecoverable: unrecoverable}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"github.com/blaize9/vuze-tools/vuze"
+	"path/filepath"
+	"strings"
+)
+
+// The recovered copy of a root level file when there is one, otherwise the one in the Azureus directory
+func recoveredOrCurrent(name string) string {
+	recovered := filepath.Join(config.GetAzRecoverPath(), name)
+	if utils.FileExists(recovered) {
+		return recovered
+	}
+	return filepath.Join(config.Get().AzureusDirectory, name)
+}
+
+// Checks the category and tag memberships of every download against the categories and tags that exist,
+// preferring the recovered configs over the ones in the Azureus directory
+func CheckConfigs() RecoveryResult {
+	result, err := checkConfigs()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return result
+}
+
+func checkConfigs() (RecoveryResult, error) {
+	log.Info("Check categories and tags\n-------------------------------")
+	dcPath := recoveredOrCurrent("downloads.config")
+	dc, err := vuze.LoadDownloadsConfig(dcPath)
+	if err != nil {
+		return RecoveryResult{}, err
+	}
+	log.Infof("Checking %s", dcPath)
+
+	categoriesPath := recoveredOrCurrent("categories.config")
+	categories, err := vuze.LoadCategoriesConfig(categoriesPath)
+	if err != nil {
+		log.Warnf("Not checking categories [%v]", err)
+	} else {
+		log.Infof("%s has %d categories", categoriesPath, len(categories.Categories))
+	}
+	tagsPath := recoveredOrCurrent("tag.config")
+	tags, err := vuze.LoadTagsConfig(tagsPath)
+	if err != nil {
+		log.Warnf("Not checking tags [%v]", err)
+	} else {
+		log.Infof("%s has %d tags", tagsPath, len(tags.Tags))
+	}
+
+	hashes := map[string]bool{}
+	valid := 0
+	unrecoverable := 0
+	for _, download := range dc.Downloads {
+		hashes[download.Hash()] = true
+		if categories != nil && download.Category != "" && !categories.Has(download.Category) {
+			unrecoverable++
+			log.Warnf("%s (%s) is in category %q which is not in %s", download.Hash(), download.SaveFile, download.Category, categoriesPath)
+			continue
+		}
+		if tags != nil {
+			for _, tag := range tags.TagsOf(download.Hash()) {
+				log.Debugf("%s (%s) is tagged %q", download.Hash(), download.SaveFile, tag.Name)
+			}
+		}
+		valid++
+	}
+
+	if tags != nil {
+		for _, tag := range tags.Tags {
+			for _, hash := range tag.Downloads {
+				if !hashes[hash] {
+					unrecoverable++
+					log.Warnf("Tag %q lists %s which is not in %s", tag.Name, hash, dcPath)
+				}
+			}
+		}
+	}
+
+	log.Infof("Downloads: %d, Valid: %d, Problems: %d", len(dc.Downloads), valid, unrecoverable)
+	return RecoveryResult{Total: len(dc.Downloads), Valid: valid, Unrecoverable: unrecoverable}, nil
+}
+
+// Reports where each file stops being valid bencode and, for torrents and active files, what salvaging would keep.
+// Files named after an info hash are only salvageable when their info dictionary still hashes to it.
+func CheckBencode(files []string) RecoveryResult {
+	if len(files) == 0 {
+		log.Fatalf("Usage: check-bencode <file> ...")
+	}
+	result := RecoveryResult{Total: len(files)}
+	for _, file := range files {
+		corrupt := utils.BencodeFileCorruption(file)
+		if corrupt == nil {
+			result.Valid++
+			fmt.Printf("%s: valid\n", file)
+			continue
+		}
+		fmt.Printf("%s: %v\n", file, corrupt)
+
+		var expected []byte
+		if hash, err := hex.DecodeString(strings.SplitN(filepath.Base(file), ".", 2)[0]); err == nil && (len(hash) == 20 || len(hash) == 32) {
+			expected = hash
+		}
+		salvaged, err := vuze.SalvageTorrentFile(file, expected)
+		if err != nil {
+			result.Unrecoverable++
+			fmt.Printf("  not salvageable: %v\n", err)
+			continue
+		}
+		result.Recovered++
+		fmt.Printf("  salvageable, keeps %s", strings.Join(salvaged.Complete, ", "))
+		if salvaged.Partial != "" {
+			fmt.Printf(" and part of %s", salvaged.Partial)
+		}
+		fmt.Println()
+	}
+	return result
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/blaize9/vuze-tools/vuze"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Exit codes returned by vuze-tools
+const (
+	ExitRecovered     = 0 // Everything was valid or has been recovered
+	ExitFatal         = 1 // A fatal error stopped the run
+	ExitAborted       = 2 // The user declined to continue
+	ExitPartial       = 3 // Some items were recovered, others are unrecoverable
+	ExitUnrecoverable = 4 // Nothing that needed recovery could be recovered
+	ExitIncomplete    = 5 // Every download was verified, some miss pieces
+)
+
+type RecoveryResult struct {
+	Total         int
+	Valid         int
+	Recovered     int
+	Unrecoverable int
+	// Downloads verify found with bad or missing pieces, they are not unrecoverable
+	Incomplete int
+}
+
+func (r RecoveryResult) ExitCode() int {
+	switch {
+	case r.Unrecoverable == 0 && r.Incomplete == 0:
+		return ExitRecovered
+	case r.Unrecoverable == 0:
+		return ExitIncomplete
+	case r.Recovered > 0:
+		return ExitPartial
+	default:
+		return ExitUnrecoverable
+	}
+}
+
+type command struct {
+	Name        string
+	Description string
+	Flags       *flag.FlagSet
+	Run         func() RecoveryResult
+	// Read only commands skip the recovery setup, plan and report
+	ReadOnly bool
+	// Commands that can run without a downloads.config in the Azureus directory
+	WithoutDownloadsConfig bool
+	// Commands that write into the Azureus directory refuse to run while Vuze is running instead of asking
+	RefuseWhileRunning bool
+	// Commands that never read the Azureus directory skip detecting it when none is configured
+	WithoutProfile bool
+}
+
+func newCommand(name string, description string, run func() RecoveryResult) *command {
+	cmd := newReadOnlyCommand(name, description, run)
+	cmd.ReadOnly = false
+	cmd.Flags.BoolVar(&utils.AssumeYes, "yes", false, "Answer yes to every confirmation prompt")
+	cmd.Flags.BoolVar(&config.Get().DryRun, "dry-run", false, "Print the planned changes without writing anything")
+	cmd.Flags.BoolVar(&config.Get().Force, "force", false, "Run even when Vuze looks like it is running")
+	return cmd
+}
+
+func newReadOnlyCommand(name string, description string, run func() RecoveryResult) *command {
+	cmd := &command{Name: name, Description: description, Run: run, ReadOnly: true}
+	cmd.Flags = flag.NewFlagSet(name, flag.ExitOnError)
+	cmd.Flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] %s [%s options]\n\n%s\n\n", os.Args[0], cmd.Name, cmd.Name, cmd.Description)
+		cmd.Flags.PrintDefaults()
+	}
+	return cmd
+}
+
+func bindSalvageFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&config.Get().Salvage, "salvage", false, "Salvage active files without a valid variant whose info dictionary is intact")
+}
+
+func bindSimpleFlags(flags *flag.FlagSet) {
+	flags.IntVar(&config.Get().SimpleRecoverWorkers, "workers", config.Get().SimpleRecoverWorkers, "Number of Simple Recovery workers")
+}
+
+func bindAdvancedFlags(flags *flag.FlagSet) {
+	flags.IntVar(&config.Get().AdvancedRecoverMaxWorkers, "max-workers", config.Get().AdvancedRecoverMaxWorkers, "Number of workers parsing backup torrents for Advanced Recovery")
+}
+
+// Writes the recovered downloads.config once the recovery has finished
+func withDownloadsConfig(run func() RecoveryResult) func() RecoveryResult {
+	return func() RecoveryResult {
+		result := run()
+		writeDownloadsConfig()
+		return result
+	}
+}
+
+func getCommands() []*command {
+	fixActive := newCommand("fix-active", "Scans active for dat files and attempts to fix them", FixActiveDatFiles)
+	bindSalvageFlags(fixActive.Flags)
+
+	simple := newCommand("simple", "Scans backups for missing torrents by filename", withDownloadsConfig(SimpleRecover))
+	bindSimpleFlags(simple.Flags)
+
+	advanced := newCommand("advanced", "Scans backup's torrents and recovers them using hashes. *Long*", withDownloadsConfig(AdvancedRecover))
+	bindAdvancedFlags(advanced.Flags)
+
+	active := newCommand("active", "Scans downloads.config and recovers torrents from active .dat files. *Fast and accurate*", withDownloadsConfig(ActiveRecover))
+
+	all := newCommand("all", "Runs fix-active, active, simple and advanced in that order", withDownloadsConfig(AllRecover))
+	bindSalvageFlags(all.Flags)
+	bindSimpleFlags(all.Flags)
+	bindAdvancedFlags(all.Flags)
+
+	rebuild := newCommand("rebuild-downloads-config", "Rebuilds downloads.config from the active .dat files", nil)
+	rebuild.WithoutDownloadsConfig = true
+	saveDir := rebuild.Flags.String("save-dir", "", "Save directory for downloads whose location is not found in any downloads.config")
+	rebuild.Run = func() RecoveryResult {
+		return RebuildDownloadsConfig(*saveDir)
+	}
+
+	repair := newCommand("repair-config", "Repairs downloads.config and the other .config files from their variants and backups. Usage: repair-config [name.config ...]", nil)
+	repair.WithoutDownloadsConfig = true
+	repair.Run = func() RecoveryResult {
+		return RepairConfigFiles(repair.Flags.Args())
+	}
+
+	merge := newCommand("merge-downloads-config", "Merges the downloads of downloads.config, its variants and every backup", nil)
+	merge.WithoutDownloadsConfig = true
+	policy := merge.Flags.String("policy", vuze.MergeNewest, fmt.Sprintf("Which entry wins when a download is listed more than once %v", vuze.MergePolicies))
+	includeRemoved := merge.Flags.Bool("include-removed", false, "Also restore downloads that are only in backups and have no active file")
+	merge.Run = func() RecoveryResult {
+		return MergeDownloadsConfig(*policy, *includeRemoved)
+	}
+
+	apply := newCommand("apply", "Copies a completed recovery directory into the Azureus directory, keeping snapshots for rollback. Usage: apply [recovery directory]", nil)
+	apply.WithoutDownloadsConfig = true
+	apply.RefuseWhileRunning = true
+	apply.Run = func() RecoveryResult {
+		return Apply(apply.Flags.Arg(0))
+	}
+
+	rollback := newCommand("rollback", "Restores the Azureus directory to how it was before the last apply", nil)
+	rollback.WithoutDownloadsConfig = true
+	rollback.RefuseWhileRunning = true
+	manifest := rollback.Flags.String("manifest", "", "Roll back this apply manifest instead of the last one")
+	rollback.Run = func() RecoveryResult {
+		return Rollback(*manifest)
+	}
+
+	verify := newCommand("verify", "Hashes the downloaded data of every download against its torrent. Usage: verify [-write-resume] [-workers=N] [hash ...]", nil)
+	writeResume := verify.Flags.Bool("write-resume", false, "Write the verified pieces into the recovered active files so Vuze skips a full recheck")
+	verifyWorkers := verify.Flags.Int("workers", runtime.NumCPU(), "Number of pieces hashed in parallel")
+	verify.Run = func() RecoveryResult {
+		return Verify(verify.Flags.Args(), *writeResume, *verifyWorkers)
+	}
+
+	relocate := newCommand("relocate", "Finds downloads whose data was moved and points them at the new location. Usage: relocate [-sample=N] <search root> ...", nil)
+	samples := relocate.Flags.Int("sample", 4, "Number of pieces hashed to confirm a candidate file")
+	relocate.Run = func() RecoveryResult {
+		return Relocate(relocate.Flags.Args(), *samples)
+	}
+
+	rewritePaths := newCommand("rewrite-paths", "Rewrites the torrent and save paths of downloads.config and the active files for a profile moved to another machine or drive. Usage: rewrite-paths -map FROM=TO ...", nil)
+	var pathRules stringList
+	rewritePaths.Flags.Var(&pathRules, "map", "Rewrite paths starting with FROM to start with TO instead, may be repeated, the first matching rule wins")
+	ignoreCase := rewritePaths.Flags.Bool("ignore-case", false, "Match every FROM ignoring case, Windows paths always are")
+	rewritePaths.Run = func() RecoveryResult {
+		return RewritePaths(pathRules, *ignoreCase)
+	}
+
+	showConfig := newReadOnlyCommand("config", "Prints the effective configuration and where each value came from. Usage: config show", nil)
+	showConfig.WithoutProfile = true
+	showConfig.Run = func() RecoveryResult {
+		return ShowConfig(showConfig.Flags.Arg(0))
+	}
+
+	checkConfig := newReadOnlyCommand("check-config", "Checks the categories and tags of every download against categories.config and tag.config", CheckConfigs)
+
+	checkBencode := newReadOnlyCommand("check-bencode", "Reports where bencoded files stop being valid and what -salvage would keep. Usage: check-bencode <file> ...", nil)
+	checkBencode.WithoutProfile = true
+	checkBencode.Run = func() RecoveryResult {
+		return CheckBencode(checkBencode.Flags.Args())
+	}
+
+	inspectActive := newReadOnlyCommand("inspect-active", "Prints everything stored in active/<hash>.dat. Usage: inspect-active [-json] [-file=path] <hash>", nil)
+	inspectJSON := inspectActive.Flags.Bool("json", false, "Print as JSON")
+	inspectFile := inspectActive.Flags.String("file", "", "Inspect this .dat file instead of active/<hash>.dat")
+	inspectActive.Run = func() RecoveryResult {
+		return InspectActive(inspectActive.Flags.Arg(0), *inspectFile, *inspectJSON)
+	}
+
+	diffActive := newReadOnlyCommand("diff-active", "Compares the .dat, .dat.bak, ._AZ and .saving variants of an active file. Usage: diff-active [-all] <hash>", nil)
+	diffAll := diffActive.Flags.Bool("all", false, "Also print the values that are the same in every variant")
+	diffActive.Run = func() RecoveryResult {
+		return DiffActive(diffActive.Flags.Arg(0), *diffAll)
+	}
+
+	return []*command{fixActive, simple, advanced, active, all, rebuild, repair, merge, apply, rollback, verify, relocate, rewritePaths, checkConfig, checkBencode, inspectActive, diffActive, showConfig}
+}
+
+// A flag that may be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func findCommand(commands []*command, name string) *command {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage(commands []*command) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <command> [command options]\n\nCommands:\n", os.Args[0])
+		for _, cmd := range commands {
+			fmt.Fprintf(os.Stderr, "  %-26s %s\n", cmd.Name, cmd.Description)
+		}
+		fmt.Fprintf(os.Stderr, "\nExit codes:\n"+
+			"  %d  everything valid or recovered\n"+
+			"  %d  fatal error\n"+
+			"  %d  aborted\n"+
+			"  %d  partially recovered\n"+
+			"  %d  nothing recoverable, or verify could not verify a download\n"+
+			"  %d  verify found incomplete downloads\n", ExitRecovered, ExitFatal, ExitAborted, ExitPartial, ExitUnrecoverable, ExitIncomplete)
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flag.PrintDefaults()
+	}
+}
@@ -1,18 +1,28 @@
 package config
 
 import (
+	_ "embed"
 	"flag"
 	"fmt"
 	"github.com/blaize9/vuze-tools/utils"
-	"github.com/jinzhu/configor"
+	"gopkg.in/yaml.v1"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 )
 
+//go:embed default_config.yml
+var defaultConfig []byte // compiled in so the binary works from any directory
+
+// Source config show reports for values of the embedded defaults
+const EmbeddedDefaults = "embedded defaults"
+
 var (
-	DefaultConfigPath = "config/default_config.yml"
-	ConfigPath        = "config/config.yml"
+	ConfigFileName = "config.yml"
+	// The config file that was loaded, empty when only the defaults are used
+	ConfigPath string
 )
 
 var config *Config
@@ -26,6 +36,7 @@ type Config struct {
 	AzureusTorrentsDirectory    string `json:"azureus_torrents_directory" yaml:"azureus_torrents_directory"`
 	AzureusDownloadsConfig      string `json:"azureus_downloads_config" yaml:"azureus_downloads_config,omitempty"`
 	AzureusRecoverTempDirectory string `json:"azureus_recover_temp_directory" yaml:"azureus_recover_temp_directory,omitempty"`
+	AzureusInstancePort         int    `json:"azureus_instance_port" yaml:"azureus_instance_port,omitempty"`
 
 	SimpleRecoverWorkers      int           `json:"simple_recovery_workers" yaml:"simple_recovery_workers,omitempty"`
 	AdvancedRecoverMaxWorkers int           `json:"advanced_recovery_max_workers" yaml:"advanced_recovery_max_workers,omitempty"`
@@ -33,6 +44,12 @@ type Config struct {
 
 	Environment string    `json:"environment" yaml:"environment,omitempty"`
 	Log         LogConfig `yaml:"log,flow,omitempty"`
+
+	// Runtime only, set from the command line
+	DryRun bool `json:"-" yaml:"-"`
+	Force  bool `json:"-" yaml:"-"`
+	// Salvage the intact part of active files without a valid variant
+	Salvage bool `json:"-" yaml:"-"`
 }
 
 type LogConfig struct {
@@ -52,8 +69,13 @@ type AzDirectories []struct {
 	Directory string
 }
 
-func init() {
-	configor.Load(Get(), ConfigPath, DefaultConfigPath)
+func (dirs AzDirectories) contains(dir string) bool {
+	for _, d := range dirs {
+		if d.Directory == dir {
+			return true
+		}
+	}
+	return false
 }
 
 func Get() *Config {
@@ -100,23 +122,104 @@ func GetAzRecoverPath() string {
 	return path
 }
 
-func BindFLags() func() {
-	var backupdirs string
-	flag.StringVar(&Get().Environment, "env", Get().Environment, "Environment [DEV,PROD,PROD-STDOUT,PROD-JSON]")
-	flag.StringVar(&Get().AzureusDirectory, "azdir", Get().AzureusDirectory, "Directory that contains Azureus storage")
-	flag.StringVar(&Get().AzureusDownloadsConfig, "azconfig", Get().AzureusDownloadsConfig, "File or FilePath to the downloads.config")
-	flag.Parse()
-	if backupdirs != "" {
-		for _, dir := range strings.Split(backupdirs, ",") {
-			dir = strings.TrimSpace(dir)
-			if utils.DirExists(dir) {
-				Get().AzureusBackupDirectories = append(Get().AzureusBackupDirectories, struct{ Directory string }{Directory: dir})
-			} else {
-				fmt.Println("Backup directory %s does not exist!", dir)
+// Reports are kept next to the recovery directory so they are never copied into the Azureus directory
+func GetAzReportsPath() string {
+	return GetAzRecoverPath() + "-reports"
+}
+
+// Snapshots of the files replaced by apply, used by rollback
+func GetAzRollbackPath() string {
+	return GetAzRecoverPath() + "-rollback"
+}
+
+// Places config.yml is looked for when -config is not given, the first one that exists is loaded:
+// $XDG_CONFIG_HOME/vuze-tools (~/.config/vuze-tools), config/ next to the executable and config/ in the working directory
+func ConfigSearchPaths() (paths []string) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "vuze-tools", ConfigFileName))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", "vuze-tools", ConfigFileName))
+	}
+	if exe, err := os.Executable(); err == nil {
+		if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+			exe = resolved
+		}
+		paths = append(paths, filepath.Join(filepath.Dir(exe), "config", ConfigFileName))
+	}
+	return append(paths, filepath.Join("config", ConfigFileName))
+}
+
+// Loads the embedded defaults and then path, or the first file of ConfigSearchPaths when path is empty
+func Load(path string) error {
+	if err := loadYaml(EmbeddedDefaults, defaultConfig); err != nil {
+		return fmt.Errorf("%s: %v", EmbeddedDefaults, err)
+	}
+	if path == "" {
+		for _, candidate := range ConfigSearchPaths() {
+			if utils.FileExists(candidate) {
+				path = candidate
+				break
 			}
 		}
+		if path == "" {
+			return nil
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
 	}
-	return func() {
-		configor.Load(Get(), ConfigPath, DefaultConfigPath)
+	if err := loadYaml(path, data); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
 	}
+	ConfigPath = path
+	return nil
+}
+
+func loadYaml(name string, data []byte) error {
+	if err := yaml.Unmarshal(data, Get()); err != nil {
+		return err
+	}
+	recordFileSources(name, data)
+	return nil
+}
+
+// The config file has to be loaded before the other flags are parsed, as they override it
+func configFlag(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+	return ""
+}
+
+// Loads the config file and applies the VUZE_TOOLS_* environment and the command line flags over it
+func BindFLags() {
+	flag.String("config", "", "Config file to load instead of searching "+strings.Join(ConfigSearchPaths(), ", "))
+	if err := Load(configFlag(os.Args[1:])); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load config %v\n", err)
+		os.Exit(1)
+	}
+	if err := applyEnv(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid environment variable %v\n", err)
+		os.Exit(1)
+	}
+	bindSettingFlags()
+	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if s, ok := f.Value.(*Setting); ok {
+			sources[s.Name] = SourceFlag + " -" + f.Name
+		}
+	})
 }
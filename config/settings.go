@@ -0,0 +1,173 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/blaize9/vuze-tools/utils"
+	"gopkg.in/yaml.v1"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Environment variables overriding the config files are VUZE_TOOLS_ followed by the upper case setting name
+const EnvPrefix = "VUZE_TOOLS_"
+
+// Where the effective value of a setting came from. Flags win over the environment, which wins over
+// config.yml, which wins over the embedded defaults.
+const (
+	SourceFlag  = "flag"
+	SourceEnv   = "env"
+	SourceUnset = "unset"
+)
+
+// Short flags kept from earlier versions
+var flagAliases = map[string]string{
+	"env":       "environment",
+	"azdir":     "azureus_directory",
+	"azconfig":  "azureus_downloads_config",
+	"azbackups": "azureus_backup_directories",
+}
+
+var sources = map[string]string{}
+
+// Setting is a single value of Config, named after its yaml key. Nested keys are joined with a dot.
+type Setting struct {
+	Name  string
+	Flag  string
+	Env   string
+	value reflect.Value
+}
+
+// Every setting of Config in declaration order
+func Settings() []*Setting {
+	return collectSettings(reflect.ValueOf(Get()).Elem(), "")
+}
+
+func collectSettings(v reflect.Value, prefix string) (settings []*Setting) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" || name == "" {
+			continue
+		}
+		name = prefix + name
+		if field.Type.Kind() == reflect.Struct {
+			settings = append(settings, collectSettings(v.Field(i), name+".")...)
+			continue
+		}
+		flatName := strings.Replace(name, ".", "_", -1)
+		settings = append(settings, &Setting{
+			Name:  name,
+			Flag:  strings.Replace(flatName, "_", "-", -1),
+			Env:   EnvPrefix + strings.ToUpper(flatName),
+			value: v.Field(i),
+		})
+	}
+	return settings
+}
+
+func (s *Setting) Source() string {
+	if source, ok := sources[s.Name]; ok {
+		return source
+	}
+	return SourceUnset
+}
+
+// Implements flag.Value
+func (s *Setting) String() string {
+	if s == nil || !s.value.IsValid() {
+		return ""
+	}
+	if dirs, ok := s.value.Interface().(AzDirectories); ok {
+		var list []string
+		for _, dir := range dirs {
+			list = append(list, dir.Directory)
+		}
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(s.value.Interface())
+}
+
+// Implements flag.Value. Backup directories are a comma separated list added to the configured ones.
+func (s *Setting) Set(value string) error {
+	switch s.value.Kind() {
+	case reflect.String:
+		s.value.SetString(value)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", s.Name)
+		}
+		s.value.SetInt(int64(i))
+	case reflect.Slice:
+		dirs := append(AzDirectories{}, s.value.Interface().(AzDirectories)...)
+		for _, dir := range strings.Split(value, ",") {
+			dir = strings.TrimSpace(dir)
+			if dir == "" || dirs.contains(dir) {
+				continue
+			}
+			if !utils.DirExists(dir) {
+				return fmt.Errorf("backup directory %s does not exist", dir)
+			}
+			dirs = append(dirs, struct{ Directory string }{Directory: dir})
+		}
+		s.value.Set(reflect.ValueOf(dirs))
+	default:
+		return fmt.Errorf("%s can not be set", s.Name)
+	}
+	return nil
+}
+
+// Records the settings set by a config file, later files win
+func recordFileSources(name string, data []byte) {
+	values := map[interface{}]interface{}{}
+	if yaml.Unmarshal(data, &values) != nil {
+		return
+	}
+	for _, s := range Settings() {
+		if hasYamlKey(values, strings.Split(s.Name, ".")) {
+			sources[s.Name] = name
+		}
+	}
+}
+
+func hasYamlKey(values map[interface{}]interface{}, path []string) bool {
+	value, ok := values[path[0]]
+	if !ok || value == nil {
+		return false
+	}
+	if len(path) == 1 {
+		return true
+	}
+	nested, ok := value.(map[interface{}]interface{})
+	return ok && hasYamlKey(nested, path[1:])
+}
+
+func applyEnv() error {
+	for _, s := range Settings() {
+		if value, ok := os.LookupEnv(s.Env); ok {
+			if err := s.Set(value); err != nil {
+				return fmt.Errorf("%s: %v", s.Env, err)
+			}
+			sources[s.Name] = SourceEnv + " " + s.Env
+		}
+	}
+	return nil
+}
+
+func bindSettingFlags() {
+	byName := map[string]*Setting{}
+	for _, s := range Settings() {
+		byName[s.Name] = s
+		usage := "Sets"
+		if s.value.Kind() == reflect.Slice {
+			usage = "Adds comma separated directories to"
+		}
+		flag.Var(s, s.Flag, fmt.Sprintf("%s %s (env %s)", usage, s.Name, s.Env))
+	}
+	for alias, name := range flagAliases {
+		flag.Var(byName[name], alias, fmt.Sprintf("Same as -%s", byName[name].Flag))
+	}
+}
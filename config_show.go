@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// Prints the effective configuration and where every value came from
+func ShowConfig(action string) RecoveryResult {
+	if action != "show" {
+		log.Fatalf("Unknown config action %q, use: config show", action)
+	}
+	if config.ConfigPath == "" {
+		fmt.Printf("Config file: none found in %s, using the %s\n\n", strings.Join(config.ConfigSearchPaths(), ", "), config.EmbeddedDefaults)
+	} else {
+		fmt.Printf("Config file: %s\n\n", config.ConfigPath)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "SETTING\tVALUE\tSOURCE\tFLAG\tENV\n")
+	for _, s := range config.Settings() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t-%s\t%s\n", s.Name, s, s.Source(), s.Flag, s.Env)
+	}
+	tw.Flush()
+	return RecoveryResult{}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"github.com/blaize9/vuze-tools/vuze"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// Prints the state stored in an active file together with its downloads.config entry
+func InspectActive(hash string, datPath string, asJSON bool) RecoveryResult {
+	if datPath == "" {
+		if hash == "" {
+			log.Fatalf("inspect-active needs a hash or -file")
+		}
+		datPath = filepath.Join(config.GetAzActivePath(), strings.ToUpper(hash)+".dat")
+	}
+
+	dat, err := vuze.LoadActiveDat(datPath)
+	if err != nil {
+		log.Fatalf("Unable to read %s [%v]", datPath, err)
+	}
+
+	var download *vuze.Download
+	if dc, err := vuze.LoadDownloadsConfig(config.GetAzDownloadsConfig()); err == nil {
+		for _, d := range dc.Downloads {
+			if d.Hash() == dat.Hash {
+				download = d
+				break
+			}
+		}
+	}
+
+	if asJSON {
+		out, err := json.MarshalIndent(struct {
+			Active   *vuze.ActiveDat `json:"active"`
+			Download *vuze.Download  `json:"download"`
+		}{dat, download}, "", "  ")
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Println(string(out))
+		return RecoveryResult{}
+	}
+
+	fmt.Print(dat)
+	if dat.Info != nil {
+		fmt.Println("Files:")
+		for i, f := range dat.Info.Files {
+			fmt.Printf("  [%d] %s (%d bytes)\n", i, f.Path, f.Length)
+		}
+	}
+	if download != nil {
+		fmt.Printf("downloads.config: torrent=%s save_dir=%s save_file=%s state=%d position=%d category=%s file_priorities=%v\n",
+			download.Torrent, download.SaveDir, download.SaveFile, download.State, download.Position, download.Category, download.FilePriorities)
+	} else {
+		fmt.Println("downloads.config: no entry for this hash")
+	}
+	return RecoveryResult{}
+}
+
+// Prints the variants of active/<hash>.dat side by side and which one fix-active would keep.
+// Only the values that differ are printed unless all is set.
+func DiffActive(hash string, all bool) RecoveryResult {
+	if hash == "" {
+		log.Fatalf("diff-active needs a hash")
+	}
+	hash = strings.ToUpper(hash)
+	variants := vuze.LoadDatVariants(config.GetAzActivePath(), hash)
+	if len(variants) == 0 {
+		log.Fatalf("No active file for %s in %s", hash, config.GetAzActivePath())
+	}
+
+	var columns [][][2]string
+	header := "FIELD"
+	for _, v := range variants {
+		columns = append(columns, vuze.DatVariantFields(v))
+		header += "\t" + v.Suffix
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, header)
+	for i, field := range columns[0] {
+		row := field[0]
+		differs := false
+		for _, column := range columns {
+			row += "\t" + column[i][1]
+			differs = differs || column[i][1] != field[1]
+		}
+		if differs || all {
+			fmt.Fprintln(tw, row)
+		}
+	}
+	tw.Flush()
+
+	if _, reason, ok := vuze.BestDatVariant(variants); ok {
+		fmt.Printf("\nBest: %s\n", reason)
+	} else {
+		fmt.Printf("\nNo usable variant: %s\n", reason)
+	}
+	return RecoveryResult{}
+}
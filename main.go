@@ -1,64 +1,141 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/IncSW/go-bencode"
 	"github.com/blaize9/vuze-tools/config"
 	"github.com/blaize9/vuze-tools/utils"
 	"github.com/blaize9/vuze-tools/utils/log"
 	"github.com/blaize9/vuze-tools/vuze"
-	"github.com/mitchellh/go-ps"
 	pbar "github.com/pmalek/pb"
-	"io/ioutil"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
-	"reflect"
 	"runtime"
 	"sort"
 	"strings"
-	"time"
+	"sync"
+	"syscall"
 )
 
 var azureusBackupDirectories []string
 
+// Every change made by the recoveries goes through the plan, which records it on every run and only skips writing with -dry-run
+var plan = &vuze.RecoveryPlan{}
+
+// What happened to every download during this run
+var report *vuze.Report
+
+// Held on the recovery directory while a command writes to it
+var lock *utils.Lock
+
+// Torrents recovered so far keyed by their downloads.config filepath, shared between recoveries run by "all"
+var recoveredTorrents = map[string]vuze.RecoveredTorrent{}
+
 // TODO: Add Tests
 // TODO: Add Documentation
 
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
+	// The command flags default to the loaded config, so the commands are only built once it is loaded
+	flag.Usage = func() { usage(getCommands())() }
 	config.BindFLags()
+	commands := getCommands()
+	flag.Usage = usage(commands)
 	log.Init(config.Get().Environment)
 
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(ExitFatal)
+	}
+	cmd := findCommand(commands, flag.Arg(0))
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(ExitFatal)
+	}
+	cmd.Flags.Parse(flag.Args()[1:])
+	if !cmd.WithoutProfile && config.Get().AzureusDirectory == "" {
+		config.Get().AzureusDirectory = detectAzureusDirectory()
+	}
+	if cmd.ReadOnly {
+		os.Exit(cmd.Run().ExitCode())
+	}
+	plan.DryRun = config.Get().DryRun
+	report = vuze.NewReport(cmd.Name)
+
+	prepare(!cmd.WithoutDownloadsConfig, cmd.RefuseWhileRunning)
+
+	result := cmd.Run()
+	if plan.DryRun {
+		fmt.Println()
+		plan.Print(os.Stdout)
+	} else {
+		jsonPath, csvPath, err := report.Save(config.GetAzReportsPath())
+		if err != nil {
+			log.Errorf("Unable to save report [%v]", err)
+		} else {
+			log.Infof("Report saved to %s and %s", jsonPath, csvPath)
+		}
+	}
+	if result.Incomplete > 0 {
+		log.Infof("Finished %s [Total: %d, Valid: %d, Incomplete: %d, Unrecoverable: %d]", cmd.Name, result.Total, result.Valid, result.Incomplete, result.Unrecoverable)
+	} else {
+		log.Infof("Finished %s [Total: %d, Valid: %d, Recovered: %d, Unrecoverable: %d]", cmd.Name, result.Total, result.Valid, result.Recovered, result.Unrecoverable)
+	}
+	lock.Release()
+	os.Exit(result.ExitCode())
+}
+
+func prepare(requireDownloadsConfig bool, refuseWhileRunning bool) {
 	log.Debugf("Config: %v", config.Get())
 	log.Infof("Using %d CPUs", runtime.NumCPU())
 	log.Infof("Azureus Directory: %s", config.Get().AzureusDirectory)
 	log.Infof("Recovery Directory: %s", config.GetAzRecoverPath())
 
-	if !utils.FileExists(config.GetAzDownloadsConfig()) {
+	if requireDownloadsConfig && !utils.FileExists(config.GetAzDownloadsConfig()) {
 		log.Fatalf("Azureus downloads.config in %s does not exist. Have you set your Azureus Directory?", config.Get().AzureusDirectory)
 	}
 
-	if !utils.DirExists(config.GetAzRecoverPath()) {
-		os.MkdirAll(config.GetAzRecoverPath(), os.FileMode(0644))
-	}
+	if config.Get().DryRun {
+		log.Infof("Dry run, nothing will be written to %s", config.GetAzRecoverPath())
+	} else {
+		if !utils.DirExists(config.GetAzRecoverPath()) {
+			os.MkdirAll(config.GetAzRecoverPath(), os.FileMode(0755))
+		}
+
+		if !utils.DirExists(filepath.Join(config.GetAzRecoverPath(), "torrents")) {
+			os.Mkdir(filepath.Join(config.GetAzRecoverPath(), "torrents"), os.FileMode(0755))
+		}
 
-	if !utils.DirExists(filepath.Join(config.GetAzRecoverPath(), "torrents")) {
-		os.Mkdir(filepath.Join(config.GetAzRecoverPath(), "torrents"), os.FileMode(0644))
+		if !utils.DirExists(filepath.Join(config.GetAzRecoverPath(), "active")) {
+			os.Mkdir(filepath.Join(config.GetAzRecoverPath(), "active"), os.FileMode(0755))
+		}
 	}
 
-	if !utils.DirExists(filepath.Join(config.GetAzRecoverPath(), "active")) {
-		os.Mkdir(filepath.Join(config.GetAzRecoverPath(), "active"), os.FileMode(0644))
+	if detected := vuze.DetectRunningVuze(config.Get().AzureusDirectory, config.Get().AzureusInstancePort); len(detected) > 0 {
+		running := strings.Join(detected, ", ")
+		switch {
+		case config.Get().Force:
+			log.Warnf("Vuze looks like it is running (%s), continuing because of -force", running)
+		case refuseWhileRunning:
+			log.Errorf("Vuze looks like it is running (%s). Close Vuze before running %s or use -force", running, flag.Arg(0))
+			os.Exit(ExitAborted)
+		case !utils.AskForconfirmation(fmt.Sprintf("Vuze looks like it is running (%s). Would you like to continue?", running)):
+			os.Exit(ExitAborted)
+		}
 	}
 
-	processes, _ := ps.Processes()
-	for _, process := range processes {
-		if strings.Contains(strings.ToLower(process.Executable()), "azureus") {
-			if !utils.AskForconfirmation(fmt.Sprintf("Found (%d) %s running. Would you like to continue?", process.Pid(), process.Executable())) {
-				os.Exit(2)
-			}
+	if !config.Get().DryRun && config.Get().LockFilename != "" {
+		var err error
+		lock, err = utils.AcquireLock(filepath.Join(config.GetAzRecoverPath(), config.Get().LockFilename))
+		if err != nil {
+			log.Errorf("Another vuze-tools is writing to %s [%v]", config.GetAzRecoverPath(), err)
+			os.Exit(ExitAborted)
 		}
 	}
 
@@ -66,152 +143,184 @@ func main() {
 		log.Infof("You have not entered any backup directories to search. Please add them if you want to run Simple or Advanced recoveries.\n")
 	}
 
+	azureusBackupDirectories = datedBackupDirectories()
+	vuze.ShuffleBackupDirectories(azureusBackupDirectories)
+	azureusBackupDirectories = append([]string{config.Get().AzureusDirectory}, azureusBackupDirectories...)
+	azureusBackupDirectories = utils.UniqueStringSlice(azureusBackupDirectories)
+}
+
+// Picks the profile directory when none is configured: the only one found, or the one the user chooses from the list
+func detectAzureusDirectory() string {
+	profiles := vuze.DetectProfiles()
+	if len(profiles) == 0 {
+		log.Fatalf("No Azureus directory is configured and none was found in %v. Set azureus_directory in config.yml, %s or use -azdir", vuze.ProfileLocations(), vuze.ProfileEnv)
+	}
+	if len(profiles) == 1 {
+		log.Infof("Detected Azureus directory %s", profiles[0].Path)
+		return profiles[0].Path
+	}
+
+	var options []string
+	for _, p := range profiles {
+		options = append(options, fmt.Sprintf("%s (%d downloads, active directory: %t, modified %s)", p.Path, p.Downloads, p.HasActive, p.ModTime.Format("2006-01-02 15:04")))
+	}
+	choice := utils.AskForChoice("No Azureus directory is configured and several were found:", options)
+	if choice < 0 {
+		log.Fatalf("No Azureus directory chosen, use -azdir to pick one")
+	}
+	return profiles[choice].Path
+}
+
+// Every dated backup directory, newest first within each configured backup directory
+func datedBackupDirectories() (dirs []string) {
 	for _, directories := range config.Get().AzureusBackupDirectories {
 		if directories.Directory == "" {
 			continue
 		}
 		for _, directory := range vuze.GetAllVuzeBackupDirectores(directories.Directory) {
-			if !utils.DirExists(directory) {
-				continue
+			if utils.DirExists(directory) {
+				dirs = append(dirs, directory)
 			}
-			azureusBackupDirectories = append(azureusBackupDirectories, directory)
+		}
+	}
+	return dirs
+}
+
+// Runs every recovery in order, each one only working on what the previous ones could not recover
+func AllRecover() RecoveryResult {
+	FixActiveDatFiles()
+	ActiveRecover()
+	SimpleRecover()
+	AdvancedRecover()
+	return resultByDownload(report)
+}
+
+// Counts the downloads of report by info hash, so the active file and the torrent of a download count once.
+// A download is unrecoverable when either of them is, recovered when either was recovered, and valid otherwise.
+func resultByDownload(report *vuze.Report) RecoveryResult {
+	states := map[string]string{}
+	for _, entry := range report.Entries {
+		key := entry.Hash
+		if key == "" {
+			key = entry.Filepath
+		}
+		if state, ok := states[key]; !ok || stateRank(entry.State) > stateRank(state) {
+			states[key] = entry.State
 		}
 	}
 
-	vuze.ShuffleBackupDirectories(azureusBackupDirectories)
-	azureusBackupDirectories = append([]string{config.Get().AzureusDirectory}, azureusBackupDirectories...)
-	azureusBackupDirectories = utils.UniqueStringSlice(azureusBackupDirectories)
+	result := RecoveryResult{Total: len(states)}
+	for _, state := range states {
+		switch state {
+		case vuze.StateValid:
+			result.Valid++
+		case vuze.StateRecovered:
+			result.Recovered++
+		default:
+			result.Unrecoverable++
+		}
+	}
+	return result
+}
 
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Println()
-	fmt.Printf("Please select a program to run\n" +
-		"1. Fix Active files (Scans active for dat files and attempts to fix them)\n" +
-		"2. Simple Recovery (Scans backups for missing torrents by filename)\n" +
-		"3. Advanced Recovery (Scans backup's torrents and recovers them using hashes.) *Long*\n" +
-		"4. Active Recovery (Scans backup.config and recovers torrents from active.dat files) *Fast and accurate*\n" +
-		"5. Exit\nSelection: ")
-	selection, _ := reader.ReadString('\n')
-	selection = strings.TrimSpace(selection)
-
-	switch selection {
-	case "1":
-		FixActiveDatFiles()
-	case "2":
-		SimpleRecover()
-	case "3":
-		AdvancedRecover()
-	case "4":
-		ActiveRecover()
+func stateRank(state string) int {
+	switch state {
+	case vuze.StateValid:
+		return 0
+	case vuze.StateRecovered:
+		return 1
 	default:
-		fmt.Println("Exiting")
-		os.Exit(2)
+		return 2
 	}
-
-	fmt.Println("Press enter key to exit.")
-	bufio.NewReader(os.Stdin).ReadBytes('\n')
 }
 
-func FixActiveDatFiles() {
+func FixActiveDatFiles() RecoveryResult {
 	log.Info("Fix Active Dat Files\n-------------------------------")
 
 	dir := config.GetAzActivePath()
 	fixedDir := path.Join(config.GetAzRecoverPath(), "active")
 
-	hashes := vuze.ProcessActiveDirectory(config.GetAzActivePath())
-	totalHashes := len(hashes)
-	bar := pbar.StartNew(totalHashes)
+	hashes := vuze.ActiveHashes(dir)
+	bar := pbar.StartNew(len(hashes))
 
 	valid := 0
 	recovered := 0
 	unrecoverable := 0
 
-	for hash, m := range hashes {
+	for _, hash := range hashes {
 		bar.Increment()
-		if m.IsDatValid && m.IsBakValid {
+		// Every variant is parsed and the most complete and recent one whose hash matches is kept as both .dat and .dat.bak
+		variants := vuze.LoadDatVariants(dir, hash)
+		best, reason, ok := vuze.BestDatVariant(variants)
+		if ok && best.Suffix == ".dat" && hasUsableVariant(variants, ".dat.bak") {
 			valid++
-		} else {
-			// .dat -> .dat.bak
-			if m.IsDatValid && !m.IsBakValid {
-				recovered++
-				err := utils.CopyFile(path.Join(dir, hash+".dat"), path.Join(fixedDir, hash+".dat.bak"))
-				if err != nil {
-					fmt.Println(err)
-				}
-				err = utils.CopyFile(path.Join(dir, hash+".dat"), path.Join(fixedDir, hash+".dat"))
-				if err != nil {
-					fmt.Println(err)
-				}
-				continue
-			}
-			// .dat.bak -> .dat
-			if m.IsBakValid && !m.IsDatValid {
-				recovered++
-				err := utils.CopyFile(path.Join(dir, hash+".dat.bak"), path.Join(fixedDir, hash+".dat"))
-				if err != nil {
-					fmt.Println(err)
-				}
-				err = utils.CopyFile(path.Join(dir, hash+".dat.bak"), path.Join(fixedDir, hash+".dat.bak"))
-				if err != nil {
-					fmt.Println(err)
-				}
-				continue
-			}
-			// .dat._AZ -> .dat .dat.bak
-			if m.IsAZValid && !m.IsBakValid && !m.IsDatValid {
-				recovered++
-				err := utils.CopyFile(path.Join(dir, hash+".dat._AZ"), path.Join(fixedDir, hash+".dat"))
-				if err != nil {
-					fmt.Println(err)
-				}
-				err = utils.CopyFile(path.Join(dir, hash+".dat._AZ"), path.Join(fixedDir, hash+".dat.bak"))
-				if err != nil {
-					fmt.Println(err)
-				}
-				continue
-			}
-			if m.IsSavingValid && !m.IsDatValid && !m.IsBakValid {
+			report.Add(hash, vuze.ReportEntry{Hash: hash, Found: true, Valid: true, State: vuze.StateValid, Reason: reason})
+			continue
+		}
+
+		if !ok && config.Get().Salvage {
+			salvaged, err := vuze.SalvageActiveDat(dir, hash)
+			if err == nil {
 				recovered++
-				err := utils.CopyFile(path.Join(dir, hash+".dat.saving"), path.Join(fixedDir, hash+".dat"))
-				if err != nil {
-					fmt.Println(err)
-				}
-				err = utils.CopyFile(path.Join(dir, hash+".dat.saving"), path.Join(fixedDir, hash+".dat.bak"))
-				if err != nil {
-					fmt.Println(err)
+				log.Warnf("Salvaged %s from %v", hash, salvaged)
+				report.Add(hash, vuze.ReportEntry{Hash: hash, Found: true, Source: "salvage", BackupPath: salvaged.Path, State: vuze.StateRecovered, Reason: reason, Error: fmt.Sprint(salvaged.Corrupt)})
+				for _, ext := range []string{".dat", ".dat.bak"} {
+					err := plan.WriteFile(path.Join(fixedDir, hash+ext), salvaged.Data, fmt.Sprintf("salvaged from %s", filepath.Base(salvaged.Path)))
+					if err != nil {
+						log.Errorf("[%s] Unable to write %s [%v]", hash, hash+ext, err)
+					}
 				}
 				continue
 			}
-			if !m.IsDatValid && !m.IsBakValid && !m.IsAZValid && !m.IsSavingValid {
-				unrecoverable++
-				log.Warnf("%s is unrecoverable [%v]\n", hash, m)
-			} else {
-				unrecoverable++
-				log.Warnf("%s is unrecoverable [%v]\n", hash, m)
+			log.Warnf("%s can not be salvaged [%v]", hash, err)
+		}
+		if !ok {
+			unrecoverable++
+			corrupt := utils.BencodeFileCorruption(path.Join(dir, hash+".dat"))
+			log.Warnf("%s is unrecoverable [%s] [.dat: %v]\n", hash, reason, corrupt)
+			report.Add(hash, vuze.ReportEntry{Hash: hash, Found: true, Source: "fix-active", State: vuze.StateUnrecoverable, Reason: reason, Error: fmt.Sprintf("no valid .dat variant [.dat: %v]", corrupt)})
+			continue
+		}
+
+		recovered++
+		log.Infof("%s: using %s", hash, reason)
+		report.Add(hash, vuze.ReportEntry{Hash: hash, Found: true, Source: "fix-active", BackupPath: best.Path, State: vuze.StateRecovered, Reason: reason})
+		for _, ext := range []string{".dat", ".dat.bak"} {
+			err := plan.CopyFile(hash, best.Path, path.Join(fixedDir, hash+ext), reason)
+			if err != nil {
+				log.Errorf("[%s] Unable to copy %s to %s [%v]", hash, hash+best.Suffix, hash+ext, err)
 			}
 		}
 	}
 	bar.FinishPrint("Recovery Finished! Please copy the files from " + config.GetAzRecoverPath())
 
-	log.Infof("Total: %d, Valid: %d, Recoverable: %d Unrecoverable: %d", valid, totalHashes, recovered, unrecoverable)
+	log.Infof("Total: %d, Valid: %d, Recoverable: %d Unrecoverable: %d", len(hashes), valid, recovered, unrecoverable)
+	return RecoveryResult{Total: len(hashes), Valid: valid, Recovered: recovered, Unrecoverable: unrecoverable}
+}
+
+func hasUsableVariant(variants []vuze.DatVariant, suffix string) bool {
+	for _, v := range variants {
+		if v.Suffix == suffix {
+			return v.Valid && v.HashMatches
+		}
+	}
+	return false
 }
 
-func SimpleRecover() {
+func SimpleRecover() RecoveryResult {
 	log.Info("Simple Recovery\n-------------------------------")
-	recoveredMap := make(chan vuze.RecoveredTorrent, 1)
-	chFinished := make(chan bool)
-	chRecovered := make(chan int)
-	chUnrecoverable := make(chan int)
-	chTorrentFiles := make(chan string, 4550)
-	chFilesCompleted := make(chan int)
+	chResults := make(chan vuze.RecoveredTorrent)
+	chTorrentFiles := make(chan vuze.TorrentPathHash, 4550)
 
 	log.Infof("Scanning Downloads config")
-	torrents, err := vuze.ScanDownloadsConfig()
+	torrents, err := vuze.ScanDownloadsConfig(recoveredOrCurrent("downloads.config"))
 	if err != nil {
 		log.Fatalf("%v", err)
-		return
 	}
 
-	torrentFiles := []string{}
+	report.AddDownloads(torrents)
+
+	torrentFiles := []vuze.TorrentPathHash{}
 	files := 0
 	valid := 0
 	for _, torrent := range torrents {
@@ -219,60 +328,77 @@ func SimpleRecover() {
 			valid++
 			continue
 		}
+		if _, ok := recoveredTorrents[torrent.Filepath]; ok {
+			continue
+		}
 		files++
-		torrentFiles = append(torrentFiles, torrent.Filepath)
+		torrentFiles = append(torrentFiles, torrent)
 	}
 
 	log.Infof("Sending %d torrents to Torrent Finder Worker", len(torrentFiles))
 	go func() {
-		for _, torrent_filepath := range torrentFiles {
-			for len(chTorrentFiles) == cap(chTorrentFiles) {
-				time.Sleep(time.Millisecond * 100)
-			}
-			chTorrentFiles <- torrent_filepath
+		for _, torrent := range torrentFiles {
+			chTorrentFiles <- torrent
 		}
+		close(chTorrentFiles)
 	}()
 
-	files_recovered_map := map[string]vuze.RecoveredTorrent{}
-	files_complete := 0
-	files_recovered := 0
-	files_unrecoverable := 0
-
+	var wg sync.WaitGroup
 	for i := 0; i < config.Get().SimpleRecoverWorkers; i++ {
-		go vuze.TorrentFinderWorker(i, chRecovered, chUnrecoverable, chTorrentFiles, chFinished, chFilesCompleted, recoveredMap, &azureusBackupDirectories)
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			vuze.TorrentFinderWorker(worker, chTorrentFiles, chResults, &azureusBackupDirectories)
+		}(i)
 	}
-	for {
-		if files == files_complete && files_recovered+files_unrecoverable == files_complete {
-			break
-		}
+	go func() {
+		wg.Wait()
+		close(chResults)
+	}()
 
-		select {
-		case recovered := <-recoveredMap:
-			files_recovered_map[recovered.OrigFilepath] = recovered
-		case <-chUnrecoverable:
-			files_unrecoverable++
-		case <-chRecovered:
-			files_recovered++
-		case <-chFilesCompleted:
-			files_complete++
+	files_recovered_map := map[string]vuze.RecoveredTorrent{}
+	files_unrecoverable_map := map[string]vuze.RecoveredTorrent{}
+	files_mismatched := 0
+	for result := range chResults {
+		if result.Err == nil {
+			files_recovered_map[result.OrigFilepath] = result
+			continue
+		}
+		files_unrecoverable_map[result.OrigFilepath] = result
+		if result.Err == vuze.ErrHashMismatch {
+			files_mismatched++
 		}
-
 	}
+	files_recovered := len(files_recovered_map)
+	files_unrecoverable := len(files_unrecoverable_map)
 
-	log.Infof("Found: %d Recoverable: %d Unrecoverable %d", files, files_recovered, files_unrecoverable)
+	log.Infof("Found: %d Recoverable: %d Unrecoverable %d (Not Found: %d, Hash Mismatch: %d)", files, files_recovered, files_unrecoverable, files_unrecoverable-files_mismatched, files_mismatched)
 
-	data, err := vuze.ReadDownloadsConfig()
-	if err != nil {
-		fmt.Errorf("%v\n", err)
+	for _, torrent := range torrentFiles {
+		if recovered, ok := files_recovered_map[torrent.Filepath]; ok {
+			report.Recovered(torrent.Filepath, "simple", recovered.BackupFilepath)
+		} else {
+			report.Unrecoverable(torrent.Filepath, "simple", files_unrecoverable_map[torrent.Filepath].Err)
+		}
 	}
-	datam := data.(map[string]interface{})
-	recoverTorrents(&datam, files_recovered_map, false)
 
+	recoverTorrents(files_recovered_map, false)
+
+	return RecoveryResult{Total: len(torrents), Valid: valid, Recovered: files_recovered, Unrecoverable: files_unrecoverable}
 }
 
-func AdvancedRecover() {
+func AdvancedRecover() RecoveryResult {
 	log.Info("Advanced Recovery\n-------------------------------")
-	HashStorage := vuze.BackupHashFinder(&azureusBackupDirectories)
+	// Ctrl-C stops the scan after saving the hash index so the next run continues from there
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	HashStorage, err := vuze.BackupHashFinder(ctx, &azureusBackupDirectories)
+	stop()
+	if err == context.Canceled {
+		lock.Release()
+		os.Exit(ExitAborted)
+	} else if err != nil {
+		log.Fatalf("%v\n", err)
+	}
 
 	log.Infof("Sorting HashStorage Hashes by newest")
 	for _, hash := range HashStorage.HashMap {
@@ -280,11 +406,11 @@ func AdvancedRecover() {
 	}
 
 	log.Infof("Scanning Downloads config")
-	torrents, err := vuze.ScanDownloadsConfig()
+	torrents, err := vuze.ScanDownloadsConfig(recoveredOrCurrent("downloads.config"))
 	if err != nil {
 		log.Fatalf("%v\n", err)
-		return
 	}
+	report.AddDownloads(torrents)
 
 	log.Infof("Selecting torrents to recover")
 
@@ -298,13 +424,18 @@ func AdvancedRecover() {
 			valid++
 			continue
 		}
+		if _, ok := recoveredTorrents[torrent.Filepath]; ok {
+			continue
+		}
 		if _, ok := HashStorage.HashMap[hex.EncodeToString(torrent.Hash)]; ok {
 			recovered++
-			log.Infof("[%d] Recovering %s", i, torrent.Filepath, torrent.Valid, torrent.Found)
+			log.Infof("[%d] Recovering %s [Found: %v, Valid: %v]", i, torrent.Filepath, torrent.Found, torrent.Valid)
 			first := HashStorage.HashMap[hex.EncodeToString(torrent.Hash)][0]
 			files_recovered_map[torrent.Filepath] = vuze.RecoveredTorrent{Filename: filepath.Base(torrent.Filepath), BackupFilepath: first.Filepath}
+			report.Recovered(torrent.Filepath, "advanced", first.Filepath)
 		} else {
 			unrecoverable++
+			report.Unrecoverable(torrent.Filepath, "advanced", errors.New("hash not found in backups"))
 			log.Warnf("[%d] Unable to recover %s [Found: %v, Valid: %v]", i, torrent.Filepath, torrent.Found, torrent.Valid)
 		}
 
@@ -312,110 +443,124 @@ func AdvancedRecover() {
 
 	log.Infof("Total: %d, Valid: %d, Recovered: %d, Unrecoverable: %d\n", len(torrents), valid, recovered, unrecoverable)
 
-	data, err := vuze.ReadDownloadsConfig()
-	if err != nil {
-		fmt.Errorf("%v", err)
-	}
-	datam := data.(map[string]interface{})
-	recoverTorrents(&datam, files_recovered_map, false)
+	recoverTorrents(files_recovered_map, false)
 
+	return RecoveryResult{Total: len(torrents), Valid: valid, Recovered: recovered, Unrecoverable: unrecoverable}
 }
 
-func ActiveRecover() {
+func ActiveRecover() RecoveryResult {
 	log.Info("Active Recovery\n-------------------------------")
 	log.Infof("Scanning Downloads config")
 	files_recovered_map := map[string]vuze.RecoveredTorrent{}
-	torrents, err := vuze.ScanDownloadsConfig()
+	torrents, err := vuze.ScanDownloadsConfig(recoveredOrCurrent("downloads.config"))
 	if err != nil {
 		log.Fatalf("%v", err)
-		return
 	}
 
-	valid := len(torrents)
+	report.AddDownloads(torrents)
+
+	valid := 0
 	recovered := 0
 	unrecoverable := 0
 
 	for _, torrent := range torrents {
+		if torrent.Valid && torrent.Found {
+			valid++
+			continue
+		}
+		if _, ok := recoveredTorrents[torrent.Filepath]; ok {
+			continue
+		}
 		log.Infof("%v\n", torrent)
-		activedat := filepath.Join(config.Get().AzureusDirectory, "active", strings.ToUpper(hex.EncodeToString(torrent.Hash))+".dat")
+		hashstring := strings.ToUpper(hex.EncodeToString(torrent.Hash))
+		activedat := filepath.Join(config.Get().AzureusDirectory, "active", hashstring+".dat")
 		log.Infof("Active File: %s\n", activedat)
 
-		if !utils.FileExists(torrent.Filepath) {
-			hashstring := strings.ToUpper(hex.EncodeToString(torrent.Hash))
-			activedat := filepath.Join(config.Get().AzureusDirectory, "active", hashstring+".dat")
-			if utils.FileExists(activedat) {
-				saved, err := vuze.SaveTorrentFromActive(activedat, filepath.Join(config.GetAzRecoverPath(), "torrents", filepath.Base(torrent.Filepath)))
-				if err != nil {
-					log.Errorf("[%s] Unable to Save torrent from active to %s [%v]", hashstring, torrent.Filepath, err)
-					unrecoverable++
-					continue
-				}
-				if saved {
-					recovered++
-					files_recovered_map[torrent.Filepath] = vuze.RecoveredTorrent{Filename: filepath.Base(torrent.Filepath), BackupFilepath: activedat}
-				}
-			} else {
-				log.Warnf("[%s] Unable to find active for %s", hashstring, torrent.Filepath)
+		if utils.FileExists(activedat) {
+			saved, err := plan.SaveTorrentFromActive(hashstring, activedat, filepath.Join(config.GetAzRecoverPath(), "torrents", filepath.Base(torrent.Filepath)))
+			if err != nil {
+				log.Errorf("[%s] Unable to Save torrent from active to %s [%v]", hashstring, torrent.Filepath, err)
 				unrecoverable++
+				report.Unrecoverable(torrent.Filepath, "active", err)
+				continue
 			}
-
+			if saved {
+				recovered++
+				files_recovered_map[torrent.Filepath] = vuze.RecoveredTorrent{Filename: filepath.Base(torrent.Filepath), BackupFilepath: activedat}
+				report.Recovered(torrent.Filepath, "active", activedat)
+			}
+		} else {
+			log.Warnf("[%s] Unable to find active for %s", hashstring, torrent.Filepath)
+			unrecoverable++
+			report.Unrecoverable(torrent.Filepath, "active", errors.New("active file not found"))
 		}
 	}
 
 	log.Infof("Total: %d, Valid: %d, Recovered: %d, Unrecoverable: %d", len(torrents), valid, recovered, unrecoverable)
+
+	recoverTorrents(files_recovered_map, true)
+
+	return RecoveryResult{Total: len(torrents), Valid: valid, Recovered: recovered, Unrecoverable: unrecoverable}
 }
 
-func recoverTorrents(datam *map[string]interface{}, files_recovered_map map[string]vuze.RecoveredTorrent, updateOnly bool) {
+// Copies the recovered torrents into the recovery directory and remembers them for writeDownloadsConfig
+func recoverTorrents(files_recovered_map map[string]vuze.RecoveredTorrent, updateOnly bool) {
 	recoverTorrentsDir := filepath.Join(config.GetAzRecoverPath(), config.Get().AzureusTorrentsDirectory)
 
-	if !updateOnly {
-		for _, recovered := range files_recovered_map {
-			if recovered.Err != nil {
-				continue
-			}
-			backupfile := recovered.BackupFilepath
-			newfile := filepath.Join(recoverTorrentsDir, recovered.Filename)
+	for torrentFilepath, recovered := range files_recovered_map {
+		if recovered.Err != nil {
+			continue
+		}
+		recoveredTorrents[torrentFilepath] = recovered
+		if updateOnly {
+			continue
+		}
 
-			if !utils.FileExists(backupfile) {
-				log.Errorf("recover file %s does not exist in expected location", backupfile)
-			}
+		backupfile := recovered.BackupFilepath
+		newfile := filepath.Join(recoverTorrentsDir, recovered.Filename)
 
-			if !utils.FileExists(newfile) && !utils.FileExists(filepath.Join(config.GetAzTorrentsPath(), recovered.Filename)) {
-				err := utils.CopyFile(backupfile, newfile)
-				if err != nil {
-					log.Warnf("Unable to copy %s to %s [%v]", backupfile, newfile, err.Error())
-				}
+		if !utils.FileExists(backupfile) {
+			log.Errorf("recover file %s does not exist in expected location", backupfile)
+		}
+
+		if !utils.FileExists(newfile) && !utils.FileExists(filepath.Join(config.GetAzTorrentsPath(), recovered.Filename)) {
+			err := plan.CopyFile("", backupfile, newfile, "recovered torrent")
+			if err != nil {
+				log.Warnf("Unable to copy %s to %s [%v]", backupfile, newfile, err.Error())
 			}
 		}
+	}
+}
 
+// Writes a downloads.config pointing every recovered torrent at its new location. It starts from the recovered
+// downloads.config when there is one, so the paths rewrite-paths or relocate changed are kept.
+func writeDownloadsConfig() {
+	dc, err := vuze.LoadDownloadsConfig(recoveredOrCurrent("downloads.config"))
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 
-	for _, v := range *datam {
-		for _, vv := range v.([]interface{}) {
-			for kkk, vvv := range vv.(map[string]interface{}) {
-				if kkk == "torrent" {
-					torrent_filepath := utils.ByteToString(vvv.([]uint8))
-					if recovered, ok := files_recovered_map[torrent_filepath]; ok {
-						if recovered.Err != nil {
-							continue
-						}
-						newfile := filepath.Join(config.GetAzTorrentsPath(), recovered.Filename)
-						reflect.ValueOf(vv).SetMapIndex(reflect.ValueOf("torrent"), reflect.ValueOf([]uint8(newfile)))
-					}
-				}
-			}
+	// Recovered torrents are copied into the torrents directory
+	rewriteDownloadPaths(dc, func(download *vuze.Download, field string, path string) string {
+		if recovered, ok := recoveredTorrents[path]; ok && field == "torrent" {
+			return filepath.Join(config.GetAzTorrentsPath(), recovered.Filename)
 		}
-	}
+		return path
+	})
 
-	dataMarshal, err := bencode.Marshal(*datam)
+	dataMarshal, err := dc.Marshal()
 	if err != nil {
-		log.Fatal("Unable to marshal downloads.config")
+		log.Fatalf("Unable to marshal downloads.config [%v]", err)
 	}
 
-	err = ioutil.WriteFile(filepath.Join(config.GetAzRecoverPath(), "downloads.config"), dataMarshal, 0644)
+	err = plan.WriteFile(filepath.Join(config.GetAzRecoverPath(), "downloads.config"), dataMarshal, "downloads.config with recovered torrent paths")
 	if err != nil {
-		log.Errorf("Unabel to write new download config [%v]", err)
+		log.Errorf("Unable to write new download config [%v]", err)
 	}
 
-	fmt.Println("Recovery Complete")
+	if plan.DryRun {
+		fmt.Println("Dry run complete, nothing was written")
+	} else {
+		fmt.Println("Recovery Complete")
+	}
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"github.com/blaize9/vuze-tools/vuze"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Merges the downloads of the current downloads.config, its variants and every dated backup.
+// Downloads missing from the current file without a valid active file were most likely removed in Vuze
+// and are left out unless includeRemoved is set.
+func MergeDownloadsConfig(policy string, includeRemoved bool) RecoveryResult {
+	log.Infof("Merge downloads.config [policy: %s]\n-------------------------------", policy)
+
+	// The current downloads.config comes first, it may also be set with -azconfig outside the Azureus directory
+	paths := []string{config.GetAzDownloadsConfig()}
+	for _, dir := range append([]string{config.Get().AzureusDirectory}, datedBackupDirectories()...) {
+		for _, variant := range vuze.FileVariants {
+			paths = append(paths, filepath.Join(dir, "downloads.config"+variant))
+		}
+	}
+	currentStat, _ := os.Stat(config.GetAzDownloadsConfig())
+
+	var current *vuze.DownloadsConfig
+	var sources []vuze.DownloadsConfigSource
+	for i, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		isCurrent := i == 0
+		if !isCurrent && currentStat != nil && os.SameFile(stat, currentStat) {
+			continue
+		}
+		source, err := vuze.LoadDownloadsConfigSource(path, isCurrent)
+		if err != nil {
+			log.Warnf("Skipping %s [%v]", path, err)
+			continue
+		}
+		if isCurrent {
+			current = source.Config
+		}
+		log.Infof("%s: %d downloads, modified %s", path, len(source.Config.Downloads), source.ModTime)
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		log.Fatalf("No readable downloads.config found")
+	}
+
+	merged, err := vuze.MergeDownloadsConfigs(sources, policy)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Active files count when fix-active would find a usable variant of them
+	active := map[string]bool{}
+	for _, hash := range vuze.ActiveHashes(config.GetAzActivePath()) {
+		_, _, active[hash] = vuze.BestDatVariant(vuze.LoadDatVariants(config.GetAzActivePath(), hash))
+	}
+	hasActive := func(hash string) bool {
+		return active[hash]
+	}
+
+	// Keys of the current downloads.config other than its downloads are kept
+	dc := current
+	if dc == nil {
+		log.Warnf("The current downloads.config is unreadable, only backups are merged")
+		dc = vuze.NewDownloadsConfig()
+	}
+	dc.Downloads = nil
+
+	listed := map[string]bool{}
+	valid := 0
+	recovered := 0
+	unrecoverable := 0
+	removed := 0
+	for _, m := range merged {
+		hash := m.Download.Hash()
+		listed[hash] = true
+		entry := vuze.ReportEntry{Hash: hash, Filepath: m.Download.Torrent, Found: true, Valid: true, Source: "merge"}
+		switch {
+		case m.InCurrent:
+			valid++
+			entry.State = vuze.StateValid
+		case !hasActive(hash) && !includeRemoved:
+			removed++
+			log.Infof("Leaving out %s (%s), it is only in %v and has no active file", hash, m.Download.SaveFile, m.Sources)
+			entry.Found = false
+			entry.State = vuze.StateMissing
+			entry.BackupPath = m.Source
+			entry.Error = "not in the current downloads.config and no active file, probably removed"
+			report.Add(hash, entry)
+			continue
+		default:
+			recovered++
+			log.Infof("Restoring %s (%s) from %s", hash, m.Download.SaveFile, m.Source)
+			entry.State = vuze.StateRecovered
+			entry.BackupPath = m.Source
+		}
+		dc.Downloads = append(dc.Downloads, m.Download)
+		report.Add(hash, entry)
+	}
+
+	// Active files nobody lists can only be added back by rebuild-downloads-config
+	var orphans []string
+	for hash, usable := range active {
+		if usable && !listed[hash] {
+			orphans = append(orphans, hash)
+		}
+	}
+	sort.Strings(orphans)
+	for _, hash := range orphans {
+		unrecoverable++
+		err := errors.New("valid active file but no downloads.config entry, run rebuild-downloads-config")
+		log.Warnf("%s has a %v", hash, err)
+		report.Add(hash, vuze.ReportEntry{Hash: hash, Found: true, Source: "merge", State: vuze.StateUnrecoverable, Error: err.Error()})
+	}
+
+	// Downloads from different files share positions, the merged queue keeps their order without duplicates
+	dc.RenumberPositions()
+	data, err := dc.Marshal()
+	if err != nil {
+		log.Fatalf("Unable to marshal downloads.config [%v]", err)
+	}
+	err = plan.WriteFile(filepath.Join(config.GetAzRecoverPath(), "downloads.config"), data, fmt.Sprintf("downloads.config merged from %d files", len(sources)))
+	if err != nil {
+		log.Errorf("Unable to write merged downloads.config [%v]", err)
+	}
+
+	log.Infof("Total: %d, Current: %d, Restored: %d, Left out: %d, Active without entry: %d", len(merged)+len(orphans), valid, recovered, removed, unrecoverable)
+	return RecoveryResult{Total: len(merged) + len(orphans), Valid: valid, Recovered: recovered, Unrecoverable: unrecoverable}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"github.com/blaize9/vuze-tools/vuze"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// A download found in an older downloads.config and where it was found
+type knownDownload struct {
+	Download *vuze.Download
+	Source   string
+}
+
+// Rebuilds downloads.config from the valid active files. Downloads still listed in a downloads.config variant
+// or a dated backup keep their save location, state and queue position, the others are rebuilt from the active file.
+func RebuildDownloadsConfig(saveDir string) RecoveryResult {
+	log.Info("Rebuild downloads.config\n-------------------------------")
+
+	if saveDir == "" {
+		if ac, err := vuze.LoadAzureusConfig(recoveredOrCurrent("azureus.config")); err == nil && ac.String(vuze.SettingDefaultSavePath) != "" {
+			saveDir = ac.String(vuze.SettingDefaultSavePath)
+			log.Infof("Using the default save path %s for downloads without a known save location", saveDir)
+		}
+	}
+	known := knownDownloads(append([]string{config.Get().AzureusDirectory}, datedBackupDirectories()...))
+	log.Infof("Found %d downloads in older downloads.config files", len(known))
+	torrents := torrentsByHash(config.GetAzTorrentsPath())
+
+	dc := vuze.NewDownloadsConfig()
+	var rebuilt []*vuze.Download
+	var lastPosition int64
+	recovered := 0
+	unrecoverable := 0
+	hashes := vuze.ActiveHashes(config.GetAzActivePath())
+	for _, hash := range hashes {
+		// The same variant fix-active keeps, the most complete and recent one whose hash matches
+		best, reason, ok := vuze.BestDatVariant(vuze.LoadDatVariants(config.GetAzActivePath(), hash))
+		if !ok {
+			unrecoverable++
+			log.Warnf("%s has no valid active file [%s]", hash, reason)
+			report.Add(hash, vuze.ReportEntry{Hash: hash, Found: true, Source: "rebuild", State: vuze.StateUnrecoverable, Reason: reason, Error: "no valid .dat variant"})
+			continue
+		}
+		activePath := best.Path
+		dat := best.Dat
+
+		previous, ok := known[hash]
+		download := previous.Download
+		source := previous.Source
+		if !ok {
+			download = vuze.NewDownloadFromActive(dat, saveDir)
+			source = activePath
+			rebuilt = append(rebuilt, download)
+			if download.SaveDir == "" {
+				log.Warnf("%s (%s) has no known save location, set it in Vuze or run again with -save-dir", hash, download.SaveFile)
+			}
+		} else if download.Position > lastPosition {
+			lastPosition = download.Position
+		}
+
+		torrent, err := rebuildTorrentPath(hash, activePath, download.Torrent, torrents)
+		if err != nil {
+			unrecoverable++
+			log.Warnf("Unable to recover the torrent of %s [%v]", hash, err)
+			report.Add(hash, vuze.ReportEntry{Hash: hash, Found: true, Source: "rebuild", State: vuze.StateUnrecoverable, Error: err.Error()})
+			continue
+		}
+		download.Torrent = torrent
+
+		recovered++
+		dc.Downloads = append(dc.Downloads, download)
+		report.Add(hash, vuze.ReportEntry{Hash: hash, Filepath: download.Torrent, Found: true, Valid: true, Source: "rebuild", BackupPath: source, State: vuze.StateRecovered})
+	}
+
+	// Downloads rebuilt from their active file go to the end of the queue
+	for _, download := range rebuilt {
+		lastPosition++
+		download.Position = lastPosition
+	}
+	sort.SliceStable(dc.Downloads, func(i, j int) bool {
+		return dc.Downloads[i].Position < dc.Downloads[j].Position
+	})
+
+	data, err := dc.Marshal()
+	if err != nil {
+		log.Fatalf("Unable to marshal downloads.config [%v]", err)
+	}
+	err = plan.WriteFile(filepath.Join(config.GetAzRecoverPath(), "downloads.config"), data, fmt.Sprintf("downloads.config rebuilt from %d active files", len(dc.Downloads)))
+	if err != nil {
+		log.Errorf("Unable to write rebuilt downloads.config [%v]", err)
+	}
+
+	log.Infof("Total: %d, Known: %d, Rebuilt: %d, Unrecoverable: %d", len(hashes), recovered-len(rebuilt), len(rebuilt), unrecoverable)
+	return RecoveryResult{Total: len(hashes), Recovered: recovered, Unrecoverable: unrecoverable}
+}
+
+// Downloads of every readable downloads.config variant in dirs, the first directory listing a hash wins
+func knownDownloads(dirs []string) map[string]knownDownload {
+	known := map[string]knownDownload{}
+	for _, dir := range dirs {
+		for _, variant := range vuze.FileVariants {
+			path := filepath.Join(dir, "downloads.config"+variant)
+			if !utils.FileExists(path) {
+				continue
+			}
+			dc, err := vuze.LoadDownloadsConfig(path)
+			if err != nil {
+				log.Debugf("Skipping %s [%v]", path, err)
+				continue
+			}
+			for _, download := range dc.Downloads {
+				if _, ok := known[download.Hash()]; !ok && len(download.TorrentHash) > 0 {
+					known[download.Hash()] = knownDownload{Download: download, Source: path}
+				}
+			}
+		}
+	}
+	return known
+}
+
+// Every torrent in dir by the lower case hex of each of its info hashes
+func torrentsByHash(dir string) map[string]string {
+	torrents := map[string]string{}
+	files, _ := ioutil.ReadDir(dir)
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".torrent" {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		hashes, err := utils.GetInfoHashesFromFile(path)
+		if err != nil {
+			continue
+		}
+		for _, key := range hashes.Keys() {
+			torrents[key] = path
+		}
+	}
+	return torrents
+}
+
+// Keeps the known torrent path when it still holds the torrent, then looks in the torrents directory
+// and finally generates the torrent from its active file
+func rebuildTorrentPath(hash string, activePath string, knownPath string, torrents map[string]string) (string, error) {
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", err
+	}
+	if knownPath != "" {
+		if hashes, err := utils.GetInfoHashesFromFile(knownPath); err == nil && hashes.Matches(hashBytes) {
+			return knownPath, nil
+		}
+	}
+	if path, ok := torrents[hex.EncodeToString(hashBytes)]; ok {
+		return path, nil
+	}
+
+	dest := filepath.Join(config.GetAzRecoverPath(), config.Get().AzureusTorrentsDirectory, hash+".torrent")
+	saved, err := plan.SaveTorrentFromActive(hash, activePath, dest)
+	if err != nil {
+		return "", err
+	}
+	if !saved {
+		return "", errors.New("torrent could not be generated from the active file")
+	}
+	return filepath.Join(config.GetAzTorrentsPath(), hash+".torrent"), nil
+}
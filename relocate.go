@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"github.com/blaize9/vuze-tools/vuze"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A download whose save path no longer exists and the file of its torrent used to find it
+type relocation struct {
+	download *vuze.Download
+	info     *vuze.TorrentInfo
+	links    map[int]string
+	anchor   int
+	active   string
+}
+
+// Finds downloads whose save path no longer exists under roots. Candidates are files with the size and name of the largest
+// file of the torrent, confirmed by hashing samples of its pieces. Downloads found in exactly one place are moved there in the
+// recovered downloads.config and active file, downloads found in several places are reported instead of guessing.
+func Relocate(roots []string, samples int) RecoveryResult {
+	log.Info("Relocate\n-------------------------------")
+	if len(roots) == 0 {
+		log.Fatalf("Usage: relocate [-sample=N] <search root> ...")
+	}
+	dc, err := vuze.LoadDownloadsConfig(recoveredOrCurrent("downloads.config"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	result := RecoveryResult{}
+	var moves []*relocation
+	sizes := map[int64]bool{}
+	for _, download := range dc.Downloads {
+		hash := download.Hash()
+		top := filepath.Join(download.SaveDir, download.SaveFile)
+		if _, err := os.Stat(top); err == nil {
+			continue
+		}
+		result.Total++
+		info, activePath, err := verifyTorrent(download)
+		if err != nil {
+			result.Unrecoverable++
+			log.Warnf("%s %s can not be relocated [%v]", hash, top, err)
+			report.Add(hash, vuze.ReportEntry{Hash: hash, Filepath: download.Torrent, Source: "relocate", State: vuze.StateUnrecoverable, Error: err.Error()})
+			continue
+		}
+		r := &relocation{download: download, info: info, active: activePath, anchor: -1}
+		if dat, err := vuze.LoadActiveDat(activePath); err == nil {
+			r.links = dat.Attributes.FileLinks
+		}
+		for i, f := range info.Files {
+			if _, linked := r.links[i]; !f.Padding && !linked && (r.anchor < 0 || f.Length > info.Files[r.anchor].Length) {
+				r.anchor = i
+			}
+		}
+		if r.anchor < 0 {
+			result.Unrecoverable++
+			log.Warnf("%s %s can not be relocated, every file is linked elsewhere", hash, top)
+			report.Add(hash, vuze.ReportEntry{Hash: hash, Filepath: download.Torrent, Source: "relocate", State: vuze.StateUnrecoverable, Error: "every file is linked elsewhere"})
+			continue
+		}
+		sizes[info.Files[r.anchor].Length] = true
+		moves = append(moves, r)
+	}
+	if len(moves) == 0 {
+		log.Infof("Total: %d, nothing to relocate", result.Total)
+		return result
+	}
+
+	log.Infof("Looking for %d downloads in %v", len(moves), roots)
+	bySize := indexFilesBySize(roots, sizes)
+
+	changed := false
+	for _, r := range moves {
+		hash := r.download.Hash()
+		from := filepath.Join(r.download.SaveDir, r.download.SaveFile)
+		entry := vuze.ReportEntry{Hash: hash, Filepath: r.download.Torrent, Source: "relocate"}
+
+		locations, partial := r.confirmedLocations(bySize[r.info.Files[r.anchor].Length], samples)
+		switch {
+		case len(locations) == 0 && len(partial) > 0:
+			result.Unrecoverable++
+			log.Warnf("%s %s was only found with bad pieces, not moving it: %s", hash, from, strings.Join(partial, ", "))
+			entry.Found = true
+			entry.State = vuze.StateUnrecoverable
+			entry.Error = "no full match, only found with bad pieces in " + strings.Join(partial, ", ")
+		case len(locations) == 0:
+			result.Unrecoverable++
+			log.Warnf("%s %s was not found", hash, from)
+			entry.State = vuze.StateMissing
+			entry.Error = "not found in " + strings.Join(roots, ", ")
+		case len(locations) == 1:
+			to := locations[0]
+			result.Recovered++
+			changed = true
+			log.Infof("%s moved from %s to %s", hash, from, to)
+			entry.Found = true
+			entry.State = vuze.StateRecovered
+			entry.BackupPath = to
+			entry.Reason = "moved from " + from
+			r.download.SaveDir, r.download.SaveFile = filepath.Dir(to), filepath.Base(to)
+			if err := rewriteActivePaths(hash, r.active, vuze.MovePath(from, to), "moved from "+from); err != nil {
+				log.Errorf("[%s] Unable to rewrite the active file [%v]", hash, err)
+			}
+		default:
+			result.Unrecoverable++
+			log.Warnf("%s %s was found in %d places, not moving it: %s", hash, from, len(locations), strings.Join(locations, ", "))
+			entry.Found = true
+			entry.State = vuze.StateUnrecoverable
+			entry.Error = "ambiguous, found in " + strings.Join(locations, ", ")
+		}
+		report.Add(hash, entry)
+	}
+
+	if changed {
+		data, err := dc.Marshal()
+		if err != nil {
+			log.Fatalf("Unable to marshal downloads.config [%v]", err)
+		}
+		err = plan.WriteFile(filepath.Join(config.GetAzRecoverPath(), "downloads.config"), data, fmt.Sprintf("%d downloads relocated", result.Recovered))
+		if err != nil {
+			log.Errorf("Unable to write downloads.config [%v]", err)
+		}
+	}
+
+	log.Infof("Total: %d, Relocated: %d, Not found, not fully matched or ambiguous: %d", result.Total, result.Recovered, result.Unrecoverable)
+	return result
+}
+
+// Files under roots with one of sizes, by size
+func indexFilesBySize(roots []string, sizes map[int64]bool) map[int64][]string {
+	bySize := map[int64][]string{}
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Warnf("Skipping %s [%v]", path, err)
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && sizes[info.Size()] {
+				bySize[info.Size()] = append(bySize[info.Size()], path)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Warnf("Unable to search %s [%v]", root, err)
+		}
+	}
+	return bySize
+}
+
+// The distinct save locations (save dir joined with save file) of the candidates whose sampled pieces all hash correctly.
+// Candidates where only some of them do are returned as partial, with how many were good.
+func (r *relocation) confirmedLocations(candidates []string, samples int) (locations []string, partial []string) {
+	anchor := r.info.Files[r.anchor]
+	// Path of the anchor below the save location, empty for single file torrents
+	rel := strings.TrimPrefix(strings.TrimPrefix(anchor.Path, r.info.Name), string(filepath.Separator))
+
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		var top string
+		if rel == "" {
+			if name := filepath.Base(candidate); name != r.download.SaveFile && name != r.info.Name {
+				continue
+			}
+			top = candidate
+		} else {
+			if !strings.HasSuffix(candidate, string(filepath.Separator)+rel) {
+				continue
+			}
+			top = strings.TrimSuffix(candidate, string(filepath.Separator)+rel)
+		}
+		resolved, err := filepath.EvalSymlinks(top)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		seen[resolved] = true
+
+		paths := vuze.DataFilePaths(r.info, filepath.Dir(top), filepath.Base(top), r.links)
+		good, checked := vuze.VerifyFileSample(r.info, paths, r.anchor, samples)
+		log.Debugf("%s: %d of %d sampled pieces are good", candidate, good, checked)
+		if checked > 0 && good == checked {
+			locations = append(locations, top)
+		} else if good > 0 {
+			partial = append(partial, fmt.Sprintf("%s (%d of %d sampled pieces good)", top, good, checked))
+		}
+	}
+	sort.Strings(locations)
+	sort.Strings(partial)
+	return locations, partial
+}
+
+// Writes the active file with its paths rewritten as recovered active/<hash>.dat and .dat.bak
+func rewriteActivePaths(hash string, activePath string, rewrite func(string) string, reason string) error {
+	data, err := ioutil.ReadFile(activePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data, changed, err := vuze.RewriteActivePaths(data, rewrite)
+	if err != nil || !changed {
+		return err
+	}
+	for _, ext := range []string{".dat", ".dat.bak"} {
+		if err := plan.WriteFile(filepath.Join(config.GetAzRecoverPath(), "active", hash+ext), data, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"github.com/blaize9/vuze-tools/vuze"
+	"os"
+	"path/filepath"
+)
+
+// Why a candidate was chosen, written to the reports directory as <name>.candidates.json
+type configRepair struct {
+	Name       string                 `json:"name"`
+	Chosen     string                 `json:"chosen,omitempty"`
+	Reason     string                 `json:"reason"`
+	Candidates []vuze.ConfigCandidate `json:"candidates"`
+}
+
+// Repairs the root level .config files named in names, or every one found, from their variants in the
+// Azureus directory and the dated backups
+func RepairConfigFiles(names []string) RecoveryResult {
+	log.Info("Repair config files\n-------------------------------")
+
+	dirs := append([]string{config.Get().AzureusDirectory}, datedBackupDirectories()...)
+	if len(names) == 0 {
+		for _, dir := range dirs {
+			names = append(names, vuze.ConfigFileNames(dir)...)
+		}
+		names = utils.UniqueStringSlice(names)
+	}
+
+	valid := 0
+	recovered := 0
+	unrecoverable := 0
+	for _, name := range names {
+		candidates := vuze.FindConfigCandidates(name, dirs)
+		for _, c := range candidates {
+			log.Debugf("%s: valid=%v entries=%d modified=%s %s", c.Path, c.Valid, c.Entries, c.ModTime, c.Error)
+		}
+		current := filepath.Join(config.Get().AzureusDirectory, name)
+		best, reason, ok := vuze.BestConfigCandidate(candidates, current)
+		if !ok {
+			unrecoverable++
+			log.Warnf("%s is unrecoverable, %s", name, reason)
+			report.Add(name, vuze.ReportEntry{Filepath: current, Found: utils.FileExists(current), Source: "repair-config", State: vuze.StateUnrecoverable, Error: reason})
+			continue
+		}
+		// A copy with the bytes of the current file recovers nothing
+		if best.Path == current || best.SameContent(vuze.EvaluateConfigCandidate(current)) {
+			valid++
+			log.Infof("%s is the best candidate, %s", current, reason)
+			report.Add(name, vuze.ReportEntry{Filepath: current, Found: true, Valid: true, State: vuze.StateValid})
+			continue
+		}
+
+		recovered++
+		log.Infof("%s: using %s, %s", name, best.Path, reason)
+		dest := filepath.Join(config.GetAzRecoverPath(), name)
+		if err := plan.CopyFile("", best.Path, dest, reason); err != nil {
+			log.Errorf("Unable to copy %s to %s [%v]", best.Path, dest, err)
+		}
+		// The explanation goes with the reports so it is not copied into the Azureus directory with the repaired file
+		explanation, _ := json.MarshalIndent(configRepair{Name: name, Chosen: best.Path, Reason: reason, Candidates: candidates}, "", "  ")
+		explanationPath := filepath.Join(config.GetAzReportsPath(), name+".candidates.json")
+		if !plan.DryRun {
+			os.MkdirAll(config.GetAzReportsPath(), os.FileMode(0755))
+		}
+		if err := plan.WriteFile(explanationPath, explanation, fmt.Sprintf("why %s was chosen", best.Path)); err != nil {
+			log.Errorf("Unable to write %s [%v]", explanationPath, err)
+		}
+		report.Add(name, vuze.ReportEntry{Filepath: current, Found: utils.FileExists(current), Source: "repair-config", BackupPath: best.Path, State: vuze.StateRecovered})
+	}
+
+	log.Infof("Total: %d, Valid: %d, Recovered: %d, Unrecoverable: %d", len(names), valid, recovered, unrecoverable)
+	if !plan.DryRun {
+		if _, err := checkConfigs(); err != nil {
+			log.Warnf("Unable to check categories and tags [%v]", err)
+		}
+	}
+	return RecoveryResult{Total: len(names), Valid: valid, Recovered: recovered, Unrecoverable: unrecoverable}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"github.com/blaize9/vuze-tools/vuze"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A path changed by a rewrite, printed as a diff
+type pathChange struct {
+	File  string
+	Hash  string
+	Field string
+	Old   string
+	New   string
+}
+
+// Rewrites the torrent path and save directory of every download with rewrite, recording each change in the plan.
+// Returns the changes per download hash.
+func rewriteDownloadPaths(dc *vuze.DownloadsConfig, rewrite func(download *vuze.Download, field string, path string) string) map[string][]pathChange {
+	changes := map[string][]pathChange{}
+	for _, download := range dc.Downloads {
+		hash := download.Hash()
+		for _, field := range []struct {
+			name string
+			path *string
+		}{{"torrent", &download.Torrent}, {"save_dir", &download.SaveDir}} {
+			rewritten := rewrite(download, field.name, *field.path)
+			if rewritten == *field.path {
+				continue
+			}
+			if field.name == "torrent" {
+				plan.RewriteTorrentPath(hash, *field.path, rewritten)
+			} else {
+				plan.RewritePath(hash, *field.path, rewritten, "downloads.config "+field.name)
+			}
+			changes[hash] = append(changes[hash], pathChange{File: "downloads.config", Hash: hash, Field: field.name, Old: *field.path, New: rewritten})
+			*field.path = rewritten
+		}
+	}
+	return changes
+}
+
+// Rewrites every torrent path and save path in downloads.config and the active files with rules, for profiles moved
+// between machines or drive letters. The recovered copies are used when there are any.
+func RewritePaths(rules []string, ignoreCase bool) RecoveryResult {
+	log.Info("Rewrite Paths\n-------------------------------")
+	var rewriter vuze.PathRewriter
+	for _, r := range rules {
+		rule, err := vuze.ParsePathRule(r, ignoreCase)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Infof("%s -> %s (ignore case: %t)", rule.From, rule.To, rule.IgnoreCase)
+		rewriter = append(rewriter, rule)
+	}
+	if len(rewriter) == 0 {
+		log.Fatalf("Usage: rewrite-paths -map FROM=TO [-map FROM=TO ...] [-ignore-case]")
+	}
+
+	dc, err := vuze.LoadDownloadsConfig(recoveredOrCurrent("downloads.config"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	changes := rewriteDownloadPaths(dc, func(download *vuze.Download, field string, path string) string {
+		return rewriter.Rewrite(path)
+	})
+	if len(changes) > 0 {
+		data, err := dc.Marshal()
+		if err != nil {
+			log.Fatalf("Unable to marshal downloads.config [%v]", err)
+		}
+		if err := plan.WriteFile(filepath.Join(config.GetAzRecoverPath(), "downloads.config"), data, "downloads.config with rewritten paths"); err != nil {
+			log.Errorf("Unable to write downloads.config [%v]", err)
+		}
+	}
+
+	// Active files that only exist in the recovery directory are rewritten too
+	recoveredActive := filepath.Join(config.GetAzRecoverPath(), "active")
+	names := map[string]bool{}
+	for _, dir := range []string{config.GetAzActivePath(), recoveredActive} {
+		files, _ := ioutil.ReadDir(dir)
+		for _, file := range files {
+			if filepath.Ext(file.Name()) == ".dat" {
+				names[file.Name()] = true
+			}
+		}
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	for _, name := range sortedNames {
+		hash := strings.TrimSuffix(name, ".dat")
+		activePath := filepath.Join(recoveredActive, name)
+		if _, err := os.Stat(activePath); err != nil {
+			activePath = filepath.Join(config.GetAzActivePath(), name)
+		}
+		err := rewriteActivePaths(hash, activePath, func(path string) string {
+			rewritten := rewriter.Rewrite(path)
+			if rewritten != path {
+				changes[hash] = append(changes[hash], pathChange{File: "active/" + name, Hash: hash, Field: "attributes", Old: path, New: rewritten})
+			}
+			return rewritten
+		}, "active file with rewritten paths")
+		if err != nil {
+			log.Errorf("[%s] Unable to rewrite the active file [%v]", hash, err)
+		}
+	}
+
+	printPathChanges(changes)
+
+	result := RecoveryResult{Total: len(dc.Downloads)}
+	for _, download := range dc.Downloads {
+		hash := download.Hash()
+		entry := vuze.ReportEntry{Hash: hash, Filepath: download.Torrent, Found: true, Valid: true, Source: "rewrite-paths", State: vuze.StateValid}
+		if n := len(changes[hash]); n > 0 {
+			result.Recovered++
+			entry.State = vuze.StateRecovered
+			entry.Reason = fmt.Sprintf("%d paths rewritten", n)
+		} else {
+			result.Valid++
+		}
+		report.Add(hash, entry)
+	}
+	log.Infof("Total: %d, Rewritten: %d, Unchanged: %d", result.Total, result.Recovered, result.Valid)
+	return result
+}
+
+// Prints the changes like a diff, grouped by download
+func printPathChanges(changes map[string][]pathChange) {
+	hashes := make([]string, 0, len(changes))
+	for hash := range changes {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		fmt.Printf("%s\n", hash)
+		for _, c := range changes[hash] {
+			fmt.Printf("  %s %s\n  - %s\n  + %s\n", c.File, c.Field, c.Old, c.New)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Returns the offset just after the bencoded value that starts at data[offset]
+func BencodeValueEnd(data []byte, offset int) (int, error) {
+	if offset >= len(data) {
+		return 0, fmt.Errorf("bencode: unexpected end of data at %d", offset)
+	}
+	switch c := data[offset]; {
+	case c == 'i':
+		end := bytes.IndexByte(data[offset:], 'e')
+		if end < 0 {
+			return 0, fmt.Errorf("bencode: unterminated integer at %d", offset)
+		}
+		if _, err := strconv.ParseInt(string(data[offset+1:offset+end]), 10, 64); err != nil {
+			return 0, fmt.Errorf("bencode: invalid integer at %d", offset)
+		}
+		return offset + end + 1, nil
+	case c == 'l' || c == 'd':
+		offset++
+		for isKey := c == 'd'; ; isKey = c == 'd' && !isKey {
+			if offset >= len(data) {
+				return 0, fmt.Errorf("bencode: unterminated %c at %d", c, offset)
+			}
+			if data[offset] == 'e' {
+				return offset + 1, nil
+			}
+			if isKey && (data[offset] < '0' || data[offset] > '9') {
+				return 0, fmt.Errorf("bencode: non-string dictionary key at %d", offset)
+			}
+			next, err := BencodeValueEnd(data, offset)
+			if err != nil {
+				return 0, err
+			}
+			offset = next
+		}
+	case c >= '0' && c <= '9':
+		colon := bytes.IndexByte(data[offset:], ':')
+		if colon < 0 {
+			return 0, fmt.Errorf("bencode: invalid string length at %d", offset)
+		}
+		length, err := strconv.Atoi(string(data[offset : offset+colon]))
+		if err != nil || length < 0 {
+			return 0, fmt.Errorf("bencode: invalid string length at %d", offset)
+		}
+		end := offset + colon + 1 + length
+		if end > len(data) {
+			return 0, fmt.Errorf("bencode: string at %d runs past the end of data", offset)
+		}
+		return end, nil
+	default:
+		return 0, fmt.Errorf("bencode: unexpected %q at %d", c, offset)
+	}
+}
+
+// Returns the exact bytes of the value stored under key in the top level dictionary
+func BencodeDictValue(data []byte, key string) ([]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, errors.New("bencode: not a dictionary")
+	}
+	offset := 1
+	for offset < len(data) && data[offset] != 'e' {
+		keyEnd, err := BencodeValueEnd(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		valueEnd, err := BencodeValueEnd(data, keyEnd)
+		if err != nil {
+			return nil, err
+		}
+		colon := bytes.IndexByte(data[offset:keyEnd], ':')
+		if colon >= 0 && string(data[offset+colon+1:keyEnd]) == key {
+			return data[keyEnd:valueEnd], nil
+		}
+		offset = valueEnd
+	}
+	return nil, fmt.Errorf("bencode: key %q not found", key)
+}
+
+// Stores value, which has to be bencoded already, under key in the top level dictionary. Every other entry is kept byte
+// for byte and a new key is inserted in sorted order.
+func BencodeSetDictValue(data []byte, key string, value []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, errors.New("bencode: not a dictionary")
+	}
+	encodedKey := []byte(strconv.Itoa(len(key)) + ":" + key)
+	offset := 1
+	for offset < len(data) && data[offset] != 'e' {
+		keyEnd, err := BencodeValueEnd(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		valueEnd, err := BencodeValueEnd(data, keyEnd)
+		if err != nil {
+			return nil, err
+		}
+		colon := bytes.IndexByte(data[offset:keyEnd], ':')
+		name := string(data[offset+colon+1 : keyEnd])
+		if name == key {
+			return concatBytes(data[:keyEnd], value, data[valueEnd:]), nil
+		}
+		if name > key {
+			break
+		}
+		offset = valueEnd
+	}
+	if offset >= len(data) {
+		return nil, errors.New("bencode: unterminated dictionary")
+	}
+	return concatBytes(data[:offset], encodedKey, value, data[offset:]), nil
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+// The info dictionary exactly as it is stored in a torrent or active file
+func InfoBytes(data []byte) ([]byte, error) {
+	info, err := BencodeDictValue(data, "info")
+	if err != nil {
+		return nil, err
+	}
+	if info[0] != 'd' {
+		return nil, errors.New("bencode: info is not a dictionary")
+	}
+	return info, nil
+}
+
+// Info hashes of a torrent, hashed from the original bytes instead of a re-encoded copy.
+// V1 is the SHA-1 of v1 and hybrid torrents, V2 the SHA-256 of v2 and hybrid torrents ("meta version" 2).
+type InfoHashes struct {
+	V1 []byte
+	V2 []byte
+}
+
+func GetInfoHashes(data []byte) (InfoHashes, error) {
+	var hashes InfoHashes
+	info, err := InfoBytes(data)
+	if err != nil {
+		return hashes, err
+	}
+	isV2 := false
+	if version, err := BencodeDictValue(info, "meta version"); err == nil && string(version) == "i2e" {
+		isV2 = true
+	}
+	_, piecesErr := BencodeDictValue(info, "pieces")
+	if !isV2 || piecesErr == nil {
+		sum := sha1.Sum(info)
+		hashes.V1 = sum[:]
+	}
+	if isV2 {
+		sum := sha256.Sum256(info)
+		hashes.V2 = sum[:]
+	}
+	return hashes, nil
+}
+
+func GetInfoHashesFromFile(path string) (InfoHashes, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return InfoHashes{}, err
+	}
+	return GetInfoHashes(data)
+}
+
+// The 20 byte hash clients use for v2 torrents where a v1 sized hash is expected
+func (h InfoHashes) V2Truncated() []byte {
+	if len(h.V2) < 20 {
+		return nil
+	}
+	return h.V2[:20]
+}
+
+// Matches a v1 hash, a full v2 hash or a truncated v2 hash
+func (h InfoHashes) Matches(hash []byte) bool {
+	if len(hash) == 0 {
+		return false
+	}
+	return bytes.Equal(hash, h.V1) || bytes.Equal(hash, h.V2) || bytes.Equal(hash, h.V2Truncated())
+}
+
+// Lower case hex of every hash the torrent can be looked up by
+func (h InfoHashes) Keys() (keys []string) {
+	for _, hash := range [][]byte{h.V1, h.V2, h.V2Truncated()} {
+		if hash != nil {
+			keys = append(keys, hex.EncodeToString(hash))
+		}
+	}
+	return keys
+}
+
+func (h InfoHashes) String() string {
+	return strings.Join(h.Keys(), "/")
+}
+
+// Upper case hex, the format Vuze uses for active/<HASH>.dat
+func HashToString(hash []byte) string {
+	return fmt.Sprintf("%X", hash)
+}
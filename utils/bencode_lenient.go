@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/zeebo/bencode"
+	"io/ioutil"
+	"strconv"
+)
+
+// BencodeError is where decoding stopped: the byte offset and the path of the value being decoded, like info.files[3].length
+type BencodeError struct {
+	Offset int
+	Path   string
+	Reason string
+}
+
+func (e *BencodeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("bencode: %s at byte %d", e.Reason, e.Offset)
+	}
+	return fmt.Sprintf("bencode: %s at byte %d in %s", e.Reason, e.Offset, e.Path)
+}
+
+type lenientDecoder struct {
+	data []byte
+	err  *BencodeError
+}
+
+// Decodes data as far as it is intact. Lists and dictionaries cut short by corruption keep the values decoded before it,
+// the corrupt value itself is left out. The error is a *BencodeError, nil only when data is a single valid value.
+func DecodeBencodeLenient(data []byte) (interface{}, error) {
+	d := &lenientDecoder{data: data}
+	value, end, ok := d.decode(0, "")
+	if ok && end < len(data) {
+		d.fail(end, "", "trailing data")
+	}
+	if d.err != nil {
+		return value, d.err
+	}
+	return value, nil
+}
+
+// Records the first, innermost, failure
+func (d *lenientDecoder) fail(offset int, path string, reason string) bool {
+	if d.err == nil {
+		d.err = &BencodeError{Offset: offset, Path: path, Reason: reason}
+	}
+	return false
+}
+
+func (d *lenientDecoder) decode(offset int, path string) (interface{}, int, bool) {
+	if offset >= len(d.data) {
+		return nil, offset, d.fail(offset, path, "unexpected end of data")
+	}
+	switch c := d.data[offset]; {
+	case c == 'i':
+		end := bytes.IndexByte(d.data[offset:], 'e')
+		if end < 0 {
+			return nil, offset, d.fail(offset, path, "unterminated integer")
+		}
+		i, err := strconv.ParseInt(string(d.data[offset+1:offset+end]), 10, 64)
+		if err != nil {
+			return nil, offset, d.fail(offset, path, "invalid integer")
+		}
+		return i, offset + end + 1, true
+	case c >= '0' && c <= '9':
+		colon := bytes.IndexByte(d.data[offset:], ':')
+		if colon < 0 {
+			return nil, offset, d.fail(offset, path, "invalid string length")
+		}
+		length, err := strconv.Atoi(string(d.data[offset : offset+colon]))
+		if err != nil || length < 0 {
+			return nil, offset, d.fail(offset, path, "invalid string length")
+		}
+		start := offset + colon + 1
+		if start+length > len(d.data) {
+			return nil, offset, d.fail(offset, path, fmt.Sprintf("string of %d bytes runs past the end of data", length))
+		}
+		return d.data[start : start+length], start + length, true
+	case c == 'l':
+		list := []interface{}{}
+		offset++
+		for i := 0; ; i++ {
+			if offset >= len(d.data) {
+				return list, offset, d.fail(offset, path, "unterminated list")
+			}
+			if d.data[offset] == 'e' {
+				return list, offset + 1, true
+			}
+			value, next, ok := d.decode(offset, fmt.Sprintf("%s[%d]", path, i))
+			if value != nil {
+				list = append(list, value)
+			}
+			if !ok {
+				return list, next, false
+			}
+			offset = next
+		}
+	case c == 'd':
+		dict := map[string]interface{}{}
+		offset++
+		for {
+			if offset >= len(d.data) {
+				return dict, offset, d.fail(offset, path, "unterminated dictionary")
+			}
+			if d.data[offset] == 'e' {
+				return dict, offset + 1, true
+			}
+			if d.data[offset] < '0' || d.data[offset] > '9' {
+				return dict, offset, d.fail(offset, path, "non-string dictionary key")
+			}
+			key, next, ok := d.decode(offset, path)
+			if !ok {
+				return dict, next, false
+			}
+			keyPath := string(key.([]byte))
+			if path != "" {
+				keyPath = path + "." + keyPath
+			}
+			value, next, ok := d.decode(next, keyPath)
+			if value != nil {
+				dict[string(key.([]byte))] = value
+			}
+			if !ok {
+				return dict, next, false
+			}
+			offset = next
+		}
+	default:
+		return nil, offset, d.fail(offset, path, fmt.Sprintf("unexpected %q", c))
+	}
+}
+
+// Rebuilds a dictionary from the intact part of data. Every complete top level entry is kept byte for byte, so hashes of
+// the info dictionary still match. A list or dictionary cut short by corruption is kept, re-encoded, with the values decoded
+// before it and returned as partial. corrupt is where decoding stopped, nil when data is valid.
+func SalvageBencodeDict(data []byte) (salvaged []byte, complete []string, partial string, corrupt error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, nil, "", &BencodeError{Reason: "not a dictionary"}
+	}
+	d := &lenientDecoder{data: data}
+	buf := bytes.NewBufferString("d")
+	offset := 1
+	for offset < len(data) && data[offset] != 'e' {
+		keyEnd, err := BencodeValueEnd(data, offset)
+		if err != nil || data[offset] < '0' || data[offset] > '9' {
+			break
+		}
+		key, _, _ := d.decode(offset, "")
+		valueEnd, err := BencodeValueEnd(data, keyEnd)
+		if err == nil {
+			buf.Write(data[offset:valueEnd])
+			complete = append(complete, string(key.([]byte)))
+			offset = valueEnd
+			continue
+		}
+		value, _, _ := d.decode(keyEnd, string(key.([]byte)))
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			encoded, err := bencode.EncodeBytes(value)
+			if err == nil {
+				buf.Write(data[offset:keyEnd])
+				buf.Write(encoded)
+				partial = string(key.([]byte))
+			}
+		}
+		break
+	}
+	buf.WriteByte('e')
+
+	if _, err := DecodeBencodeLenient(data); err != nil {
+		corrupt = err
+	}
+	return buf.Bytes(), complete, partial, corrupt
+}
+
+// Where a bencoded file stops being valid, nil when it is valid
+func BencodeFileCorruption(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("bencode: empty file")
+	}
+	_, err = DecodeBencodeLenient(data)
+	return err
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/go-ps"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Lock is an exclusive lock file recording who holds it. The file stays open and locked while it is held, so a crashed
+// process releases it and two processes can never both take over a stale lock.
+type Lock struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+	path    string
+	file    *os.File
+}
+
+// Opens and locks the lock file at path. A lock left by a process that no longer runs on this host is stale and replaced.
+func AcquireLock(path string) (*Lock, error) {
+	host, _ := os.Hostname()
+	lock := &Lock{PID: os.Getpid(), Host: host, Started: time.Now(), path: path}
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(file); err != nil {
+			file.Close()
+			return nil, heldLockError(path)
+		}
+		// The holder may have released and removed the file between opening and locking it
+		if !isSameFile(file, path) {
+			file.Close()
+			continue
+		}
+		// The file lock proves nobody on this host holds it, even when the pid of a crashed holder was reused.
+		// Only locks of other hosts, or of platforms without file locks, are checked by their pid.
+		if holder, err := ReadLock(path); err == nil && holder.PID != 0 && (!fileLocks || holder.Host != host) && !holder.IsStale() {
+			file.Close()
+			return nil, heldLockError(path)
+		} else if err == nil && holder.PID != 0 {
+			fmt.Fprintf(os.Stderr, "Replacing stale lock %s of pid %d started %s\n", path, holder.PID, holder.Started.Format(time.RFC3339))
+		}
+		if err = file.Truncate(0); err == nil {
+			_, err = file.WriteAt(data, 0)
+		}
+		if err != nil {
+			os.Remove(path)
+			file.Close()
+			return nil, err
+		}
+		lock.file = file
+		return lock, nil
+	}
+	return nil, fmt.Errorf("unable to acquire %s", path)
+}
+
+func heldLockError(path string) error {
+	holder, err := ReadLock(path)
+	if err != nil || holder.PID == 0 {
+		return fmt.Errorf("%s is held by another process", path)
+	}
+	return fmt.Errorf("%s is held by pid %d on %s since %s", path, holder.PID, holder.Host, holder.Started.Format(time.RFC3339))
+}
+
+func isSameFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, current)
+}
+
+func ReadLock(path string) (*Lock, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lock := &Lock{path: path}
+	return lock, json.Unmarshal(data, lock)
+}
+
+// A lock is stale when its process is gone. Locks of other hosts can not be checked and are never stale.
+func (l *Lock) IsStale() bool {
+	host, _ := os.Hostname()
+	if l.Host != host {
+		return false
+	}
+	process, err := ps.FindProcess(l.PID)
+	return err == nil && process == nil
+}
+
+// Removes the lock file before unlocking it, processes that opened it meanwhile notice it is gone and retry
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	err := os.Remove(l.path)
+	l.file.Close()
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package utils
+
+import "os"
+
+// Files can not be locked here, the pid recorded in the lock file is all there is to check
+const fileLocks = false
+
+func lockFile(file *os.File) error {
+	return nil
+}
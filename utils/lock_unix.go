@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// Files are locked with flock here
+const fileLocks = true
+
+// Takes an exclusive flock on file without waiting, the kernel releases it when the process exits
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -13,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -113,13 +114,37 @@ func IsTorrentValid(filepath string) error {
 	if er != nil {
 		return errors.New("Read")
 	}
-	_, err := bencode.Unmarshal(file)
+	_, err := BencodeUnmarshal(file)
 	if err != nil {
 		return errors.New("Torrent")
 	}
 	return nil
 }
 
+// bencode.Unmarshal that returns an error instead of panicking on truncated or empty data
+func BencodeUnmarshal(data []byte) (result interface{}, err error) {
+	if len(data) == 0 {
+		return nil, errors.New("bencode: empty data")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			err = fmt.Errorf("bencode: invalid data [%v]", r)
+		}
+	}()
+	return bencode.Unmarshal(data)
+}
+
+// Copies Filepath next to destFilepath and renames it over destFilepath, so destFilepath is never left half written
+func ReplaceFile(Filepath string, destFilepath string) error {
+	tmp := destFilepath + ".vuze-tools.tmp"
+	if err := CopyFile(Filepath, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, destFilepath)
+}
+
 // Encode via Gob to file
 func SaveStruct(path string, object interface{}) error {
 	file, err := os.Create(path)
@@ -168,9 +193,17 @@ func SliceContains(slice []string, item string) bool {
 	return ok
 }
 
+// AssumeYes makes AskForconfirmation answer every prompt with yes without reading stdin.
+var AssumeYes bool
+
 func AskForconfirmation(msg string) bool {
 	var s string
 
+	if AssumeYes {
+		fmt.Printf("%s (Y/N): Y\n", msg)
+		return true
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Printf("%s (Y/N): ", msg)
 
@@ -185,6 +218,26 @@ func AskForconfirmation(msg string) bool {
 	return false
 }
 
+// Asks to pick one of options and returns its index, or -1 when nothing valid was picked or prompts are answered by AssumeYes
+func AskForChoice(msg string, options []string) int {
+	fmt.Println(msg)
+	for i, option := range options {
+		fmt.Printf("  %d) %s\n", i+1, option)
+	}
+	if AssumeYes {
+		return -1
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Printf("Choice (1-%d): ", len(options))
+	s, _ := reader.ReadString('\n')
+	choice, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || choice < 1 || choice > len(options) {
+		return -1
+	}
+	return choice - 1
+}
+
 func ShuffleSlice(slice []string) {
 	for i := range slice {
 		j := rand.Intn(i + 1)
@@ -197,7 +250,7 @@ func IsBencodeFileValid(path string) bool {
 	if err != nil {
 		return false
 	}
-	_, err = bencode.Unmarshal(file)
+	_, err = BencodeUnmarshal(file)
 	if err == nil {
 		return true
 	}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"github.com/blaize9/vuze-tools/vuze"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Bad pieces listed per download before the rest are only counted
+const maxListedPieceRanges = 20
+
+// Hashes the data of every download (or only those of hashes) against its torrent and reports how complete it is.
+// With writeResume the piece states are written into the recovered active file so Vuze skips a full recheck.
+func Verify(hashes []string, writeResume bool, workers int) RecoveryResult {
+	log.Info("Verify\n-------------------------------")
+	dc, err := vuze.LoadDownloadsConfig(recoveredOrCurrent("downloads.config"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	wanted := map[string]bool{}
+	for _, hash := range hashes {
+		wanted[strings.ToUpper(hash)] = true
+	}
+
+	// Ctrl-C stops after the download being hashed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result := RecoveryResult{}
+	for _, download := range dc.Downloads {
+		hash := download.Hash()
+		if len(wanted) > 0 && !wanted[hash] {
+			continue
+		}
+		result.Total++
+		entry := vuze.ReportEntry{Hash: hash, Filepath: download.Torrent, Source: "verify"}
+
+		info, activePath, err := verifyTorrent(download)
+		if err != nil {
+			result.Unrecoverable++
+			log.Warnf("%s %s can not be verified [%v]", hash, download.SaveFile, err)
+			entry.State = vuze.StateUnrecoverable
+			entry.Error = err.Error()
+			report.Add(hash, entry)
+			continue
+		}
+		entry.Found = true
+
+		var links map[int]string
+		if dat, err := vuze.LoadActiveDat(activePath); err == nil {
+			links = dat.Attributes.FileLinks
+		}
+		paths := vuze.DataFilePaths(info, download.SaveDir, download.SaveFile, links)
+		v, err := vuze.VerifyPieces(ctx, info, paths, workers)
+		if err == context.Canceled {
+			lock.Release()
+			os.Exit(ExitAborted)
+		} else if err != nil {
+			result.Unrecoverable++
+			log.Warnf("%s %s can not be verified [%v]", hash, download.SaveFile, err)
+			entry.State = vuze.StateUnrecoverable
+			entry.Error = err.Error()
+			report.Add(hash, entry)
+			continue
+		}
+
+		entry.Reason = fmt.Sprintf("%.2f%% complete, %d of %d pieces done", v.Completion(), v.Good, v.Pieces)
+		if v.Complete() {
+			result.Valid++
+			entry.Valid = true
+			entry.State = vuze.StateValid
+			log.Infof("%s %s: complete (%d pieces)", hash, download.SaveFile, v.Pieces)
+		} else {
+			result.Incomplete++
+			entry.State = vuze.StateIncomplete
+			entry.Error = fmt.Sprintf("bad pieces %s", pieceRanges(v.BadPieces))
+			if len(v.Missing) > 0 {
+				entry.Error += fmt.Sprintf(", missing %s", strings.Join(v.Missing, ", "))
+			}
+			log.Warnf("%s %s: %s, %s", hash, download.SaveFile, entry.Reason, entry.Error)
+		}
+		report.Add(hash, entry)
+
+		if writeResume {
+			if err := writeResumeStates(hash, activePath, v); err != nil {
+				log.Errorf("[%s] Unable to write the resume data [%v]", hash, err)
+			}
+		}
+	}
+
+	log.Infof("Total: %d, Complete: %d, Incomplete: %d, Unverifiable: %d", result.Total, result.Valid, result.Incomplete, result.Unrecoverable)
+	return result
+}
+
+// The torrent of a download from the recovered or current torrents directory, or else its active file.
+// Also returns the active file, preferring the recovered copy, for the file links and the resume data.
+func verifyTorrent(download *vuze.Download) (*vuze.TorrentInfo, string, error) {
+	hash := download.Hash()
+	activePath := filepath.Join(config.GetAzRecoverPath(), "active", hash+".dat")
+	if !utils.FileExists(activePath) {
+		activePath = filepath.Join(config.GetAzActivePath(), hash+".dat")
+	}
+
+	var sources []string
+	if download.Torrent != "" {
+		sources = append(sources, filepath.Join(config.GetAzRecoverPath(), "torrents", filepath.Base(download.Torrent)), download.Torrent)
+	}
+	sources = append(sources, activePath)
+
+	var errs []string
+	for _, source := range sources {
+		data, err := ioutil.ReadFile(source)
+		if err != nil {
+			continue
+		}
+		hashes, err := utils.GetInfoHashes(data)
+		if err != nil || !hashes.Matches(download.TorrentHash) {
+			errs = append(errs, fmt.Sprintf("%s does not match", source))
+			continue
+		}
+		torrent, err := vuze.UnmarshalActiveDat(data)
+		if err != nil || torrent.Info == nil {
+			errs = append(errs, fmt.Sprintf("%s [%v]", source, err))
+			continue
+		}
+		return torrent.Info, activePath, nil
+	}
+	if len(errs) == 0 {
+		errs = append(errs, "no torrent or active file found")
+	}
+	return nil, activePath, fmt.Errorf("%s", strings.Join(errs, ", "))
+}
+
+// Writes the verified piece states into the active file as recovered active/<hash>.dat and .dat.bak
+func writeResumeStates(hash string, activePath string, v *vuze.Verification) error {
+	data, err := ioutil.ReadFile(activePath)
+	if err != nil {
+		return err
+	}
+	data, err = vuze.SetResumePieceStates(data, v.States)
+	if err != nil {
+		return err
+	}
+	for _, ext := range []string{".dat", ".dat.bak"} {
+		dest := filepath.Join(config.GetAzRecoverPath(), "active", hash+ext)
+		if err := plan.WriteFile(dest, data, fmt.Sprintf("verified resume data, %d of %d pieces done", v.Good, v.Pieces)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pieces as ranges like 0-4, 9, 12-13, only the first maxListedPieceRanges ranges are listed
+func pieceRanges(pieces []int) string {
+	var ranges []string
+	for i := 0; i < len(pieces); {
+		j := i
+		for j+1 < len(pieces) && pieces[j+1] == pieces[j]+1 {
+			j++
+		}
+		if len(ranges) == maxListedPieceRanges {
+			ranges = append(ranges, fmt.Sprintf("and %d more", len(pieces)-i))
+			break
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprint(pieces[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", pieces[i], pieces[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
@@ -0,0 +1,244 @@
+package vuze
+
+import (
+	"errors"
+	"fmt"
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/zeebo/bencode"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Piece states stored in the resume data of an active file
+const (
+	PieceNotDone         = 0
+	PieceDone            = 1
+	PieceStarted         = 2
+	PieceRecheckRequired = 3
+)
+
+// Keys Vuze adds to the torrent when saving it as active/<HASH>.dat, everything else belongs to the torrent
+var activeStateKeys = []string{"attributes", "resume", "tracker_cache", "stats"}
+
+// ActiveDat is a decoded active/<HASH>.dat file, the torrent plus the state Vuze keeps for the download
+type ActiveDat struct {
+	Hash         string           `json:"hash"`
+	Path         string           `json:"path"`
+	Announce     string           `json:"announce"`
+	AnnounceList [][]string       `json:"announce_list,omitempty"`
+	Comment      string           `json:"comment,omitempty"`
+	CreatedBy    string           `json:"created_by,omitempty"`
+	CreationDate int64            `json:"creation_date,omitempty"`
+	Encoding     string           `json:"encoding,omitempty"`
+	Info         *TorrentInfo     `json:"info"`
+	Resume       ActiveResume     `json:"resume"`
+	Attributes   ActiveAttributes `json:"attributes"`
+	Stats        ActiveStats      `json:"stats"`
+	TrackerCache []string         `json:"tracker_cache,omitempty"`
+	Peers        []CachedPeer     `json:"peer_cache,omitempty"`
+	raw          map[string]interface{}
+	rawInfo      []byte
+}
+
+// Resume data written by Vuze's disk manager
+type ActiveResume struct {
+	Valid         bool            `json:"valid"`
+	PieceStates   []byte          `json:"-"`
+	PartialPieces map[int][]int64 `json:"partial_pieces,omitempty"`
+}
+
+// Download manager attributes, including where the data is saved
+type ActiveAttributes struct {
+	Category       string         `json:"category,omitempty"`
+	DisplayName    string         `json:"display_name,omitempty"`
+	RelativePath   string         `json:"relative_path,omitempty"`
+	FileLinks      map[int]string `json:"file_links,omitempty"`
+	FilePriorities []int64        `json:"file_priorities,omitempty"`
+	FileDownloaded []int64        `json:"file_downloaded,omitempty"`
+	Networks       []string       `json:"networks,omitempty"`
+	PeerSources    []string       `json:"peer_sources,omitempty"`
+}
+
+type ActiveStats struct {
+	Downloaded         int64 `json:"downloaded"`
+	Uploaded           int64 `json:"uploaded"`
+	Completed          int64 `json:"completed"`
+	Discarded          int64 `json:"discarded"`
+	HashFailBytes      int64 `json:"hash_fail_bytes"`
+	SecondsDownloading int64 `json:"seconds_downloading"`
+	SecondsOnlySeeding int64 `json:"seconds_only_seeding"`
+}
+
+type CachedPeer struct {
+	IP     string `json:"ip"`
+	Port   int64  `json:"port"`
+	Source string `json:"source,omitempty"`
+}
+
+func LoadActiveDat(path string) (*ActiveDat, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dat, err := UnmarshalActiveDat(file)
+	if err != nil {
+		return nil, err
+	}
+	dat.Path = path
+	dat.Hash = strings.ToUpper(strings.SplitN(filepath.Base(path), ".", 2)[0])
+	return dat, nil
+}
+
+func UnmarshalActiveDat(data []byte) (*ActiveDat, error) {
+	decoded, err := utils.BencodeUnmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	datam := bencodeMap(decoded)
+	if datam == nil {
+		return nil, errors.New("active file is not a dictionary")
+	}
+
+	dat := &ActiveDat{
+		Announce:     bencodeString(datam["announce"]),
+		Comment:      bencodeString(datam["comment"]),
+		CreatedBy:    bencodeString(datam["created by"]),
+		CreationDate: bencodeInt(datam["creation date"]),
+		Encoding:     bencodeString(datam["encoding"]),
+		raw:          datam,
+	}
+	for _, tier := range bencodeList(datam["announce-list"]) {
+		dat.AnnounceList = append(dat.AnnounceList, bencodeStringList(tier))
+	}
+	if info := bencodeMap(datam["info"]); info != nil {
+		dat.Info, _ = ParseTorrentInfo(info)
+		dat.rawInfo, _ = utils.InfoBytes(data)
+	}
+
+	resume := bencodeMap(bencodeMap(datam["resume"])["data"])
+	dat.Resume.Valid = bencodeInt(resume["valid"]) == 1
+	dat.Resume.PieceStates, _ = resume["resume data"].([]byte)
+	for piece, blocks := range bencodeMap(resume["blocks"]) {
+		if i, err := strconv.Atoi(piece); err == nil {
+			if dat.Resume.PartialPieces == nil {
+				dat.Resume.PartialPieces = map[int][]int64{}
+			}
+			dat.Resume.PartialPieces[i] = bencodeIntList(blocks)
+		}
+	}
+
+	attributes := bencodeMap(datam["attributes"])
+	dat.Attributes = ActiveAttributes{
+		Category:       bencodeString(attributes["category"]),
+		DisplayName:    bencodeString(attributes["displayname"]),
+		RelativePath:   bencodeString(attributes["relativepath"]),
+		FilePriorities: bencodeIntList(attributes["file_priorities"]),
+		FileDownloaded: bencodeIntList(attributes["filedownloaded"]),
+		Networks:       bencodeStringList(attributes["networks"]),
+		PeerSources:    bencodeStringList(attributes["peersources"]),
+	}
+	// filelinks2 entries are "<index>\n<source>\n<target>"
+	for _, link := range bencodeStringList(attributes["filelinks2"]) {
+		parts := strings.Split(link, "\n")
+		if i, err := strconv.Atoi(parts[0]); err == nil && len(parts) == 3 {
+			if dat.Attributes.FileLinks == nil {
+				dat.Attributes.FileLinks = map[int]string{}
+			}
+			dat.Attributes.FileLinks[i] = parts[2]
+		}
+	}
+
+	stats := bencodeMap(datam["stats"])
+	dat.Stats = ActiveStats{
+		Downloaded:         bencodeInt(stats["downloaded"]),
+		Uploaded:           bencodeInt(stats["uploaded"]),
+		Completed:          bencodeInt(stats["completed"]),
+		Discarded:          bencodeInt(stats["discarded"]),
+		HashFailBytes:      bencodeInt(stats["hashfailbytes"]),
+		SecondsDownloading: bencodeInt(stats["secondsDownloading"]),
+		SecondsOnlySeeding: bencodeInt(stats["secondsOnlySeeding"]),
+	}
+
+	trackerCache := bencodeMap(datam["tracker_cache"])
+	for key := range trackerCache {
+		if key != "tracker_peers" {
+			dat.TrackerCache = append(dat.TrackerCache, key)
+		}
+	}
+	sort.Strings(dat.TrackerCache)
+	for _, p := range bencodeList(trackerCache["tracker_peers"]) {
+		peer := bencodeMap(p)
+		dat.Peers = append(dat.Peers, CachedPeer{IP: bencodeString(peer["ip"]), Port: bencodeInt(peer["port"]), Source: bencodeString(peer["src"])})
+	}
+
+	return dat, nil
+}
+
+// Builds the torrent stored in the active file, leaving out the download state Vuze added
+func (dat *ActiveDat) Torrent() ([]byte, error) {
+	if dat.Info == nil {
+		return nil, errors.New("active file has no info dictionary")
+	}
+	torrent := map[string]interface{}{}
+	for k, v := range dat.raw {
+		if !utils.SliceContains(activeStateKeys, k) {
+			torrent[k] = v
+		}
+	}
+	// Re-encoding info could reorder its keys and change the info hash, so the original bytes are used.
+	// "piece layers" of v2 and hybrid torrents is a top level key and is copied with the rest.
+	if dat.rawInfo != nil {
+		torrent["info"] = bencode.RawMessage(dat.rawInfo)
+	}
+	return bencode.EncodeBytes(torrent)
+}
+
+// Info hashes of the info dictionary as stored in the active file
+func (dat *ActiveDat) InfoHashes() (utils.InfoHashes, error) {
+	if dat.rawInfo == nil {
+		return utils.InfoHashes{}, errors.New("active file has no info dictionary")
+	}
+	return utils.GetInfoHashes(append(append([]byte("d4:info"), dat.rawInfo...), 'e'))
+}
+
+// Number of pieces marked done in the resume data
+func (r ActiveResume) CompletedPieces() (done int) {
+	for _, state := range r.PieceStates {
+		if state == PieceDone {
+			done++
+		}
+	}
+	return done
+}
+
+func (r ActiveResume) Completion() float64 {
+	if len(r.PieceStates) == 0 {
+		return 0
+	}
+	return float64(r.CompletedPieces()) / float64(len(r.PieceStates)) * 100
+}
+
+// A valid active file decodes and contains the torrent's info dictionary
+func IsActiveDatValid(path string) bool {
+	dat, err := LoadActiveDat(path)
+	return err == nil && dat.Info != nil
+}
+
+func (dat *ActiveDat) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hash: %s\nPath: %s\n", dat.Hash, dat.Path)
+	if dat.Info != nil {
+		hashes, _ := dat.InfoHashes()
+		fmt.Fprintf(&b, "Meta Version: %d\nInfo Hashes: %s\n", dat.Info.MetaVersion, hashes)
+		fmt.Fprintf(&b, "Name: %s\nSize: %d bytes in %d files\nPieces: %d x %d bytes\n", dat.Info.Name, dat.Info.TotalLength(), len(dat.Info.Files), dat.Info.PieceCount(), dat.Info.PieceLength)
+	}
+	fmt.Fprintf(&b, "Announce: %s\nComment: %s\nCreated By: %s\nCreation Date: %d\n", dat.Announce, dat.Comment, dat.CreatedBy, dat.CreationDate)
+	fmt.Fprintf(&b, "Resume: valid=%v, %d/%d pieces done (%.2f%%), %d partial pieces\n", dat.Resume.Valid, dat.Resume.CompletedPieces(), len(dat.Resume.PieceStates), dat.Resume.Completion(), len(dat.Resume.PartialPieces))
+	fmt.Fprintf(&b, "Category: %s\nRelative Path: %s\nFile Links: %v\nFile Priorities: %v\n", dat.Attributes.Category, dat.Attributes.RelativePath, dat.Attributes.FileLinks, dat.Attributes.FilePriorities)
+	fmt.Fprintf(&b, "Stats: downloaded=%d uploaded=%d completed=%d discarded=%d hashfail=%d\n", dat.Stats.Downloaded, dat.Stats.Uploaded, dat.Stats.Completed, dat.Stats.Discarded, dat.Stats.HashFailBytes)
+	fmt.Fprintf(&b, "Tracker Cache: %v\nPeer Cache: %d peers\n", dat.TrackerCache, len(dat.Peers))
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package vuze
+
+import (
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/zeebo/bencode"
+	"path/filepath"
+	"strings"
+)
+
+// Rewrites the save path (relativepath) and the file links (filelinks2) stored in the attributes of an active file,
+// keeping everything else byte for byte. Returns whether anything changed.
+func RewriteActivePaths(data []byte, rewrite func(string) string) ([]byte, bool, error) {
+	raw, err := utils.BencodeDictValue(data, "attributes")
+	if err != nil {
+		// Without attributes Vuze takes the save path from downloads.config
+		return data, false, nil
+	}
+	decoded, err := utils.BencodeUnmarshal(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	attributes := bencodeMap(decoded)
+	if attributes == nil {
+		return data, false, nil
+	}
+
+	changed := false
+	if path := bencodeString(attributes["relativepath"]); path != "" {
+		if rewritten := rewrite(path); rewritten != path {
+			attributes["relativepath"] = []byte(rewritten)
+			changed = true
+		}
+	}
+	// filelinks2 entries are "<index>\n<source>\n<target>"
+	links := bencodeList(attributes["filelinks2"])
+	for i, link := range links {
+		parts := strings.Split(bencodeString(link), "\n")
+		if len(parts) != 3 {
+			continue
+		}
+		source, target := rewrite(parts[1]), rewrite(parts[2])
+		if source != parts[1] || target != parts[2] {
+			links[i] = []byte(strings.Join([]string{parts[0], source, target}, "\n"))
+			changed = true
+		}
+	}
+	if !changed {
+		return data, false, nil
+	}
+
+	encoded, err := bencode.EncodeBytes(attributes)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err = utils.BencodeSetDictValue(data, "attributes", encoded)
+	return data, err == nil, err
+}
+
+// A rewrite for RewriteActivePaths that moves everything at or below from to to
+func MovePath(from string, to string) func(string) string {
+	from = filepath.Clean(from)
+	return func(path string) string {
+		clean := filepath.Clean(path)
+		if clean == from {
+			return to
+		}
+		if strings.HasPrefix(clean, from+string(filepath.Separator)) {
+			return filepath.Join(to, strings.TrimPrefix(clean, from))
+		}
+		return path
+	}
+}
@@ -0,0 +1,121 @@
+package vuze
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/blaize9/vuze-tools/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Files of the recovery directory that belong to vuze-tools and are never applied
+var applyExcludes = []string{"hashindex.json", "hashindex.json.tmp", "hashstorage.struct"}
+
+// ApplyManifest records what apply replaced so rollback can restore it.
+// It is written once every snapshot was taken and before anything is replaced, so an interrupted apply can be rolled back too.
+type ApplyManifest struct {
+	Applied     time.Time     `json:"applied"`
+	RolledBack  *time.Time    `json:"rolled_back,omitempty"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Files       []AppliedFile `json:"files"`
+	path        string
+}
+
+// AppliedFile is a file apply wrote, relative to the destination. Existing files are snapshotted first.
+type AppliedFile struct {
+	Path     string `json:"path"`
+	Existed  bool   `json:"existed"`
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// Files of a recovery directory to apply, relative to it
+func RecoveryFiles(recoveryDir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(recoveryDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(recoveryDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == config.Get().LockFilename {
+			return nil
+		}
+		for _, exclude := range applyExcludes {
+			if rel == exclude {
+				return nil
+			}
+		}
+		files = append(files, rel)
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// Creates the manifest of applying files from source to destination, with snapshots stored under dir
+func NewApplyManifest(dir string, source string, destination string, files []string) *ApplyManifest {
+	m := &ApplyManifest{Applied: time.Now(), Source: source, Destination: destination, path: filepath.Join(dir, "manifest.json")}
+	for _, file := range files {
+		applied := AppliedFile{Path: file}
+		if _, err := os.Stat(filepath.Join(destination, file)); err == nil {
+			applied.Existed = true
+			applied.Snapshot = filepath.Join(dir, "files", file)
+		}
+		m.Files = append(m.Files, applied)
+	}
+	return m
+}
+
+func (m *ApplyManifest) Save() error {
+	if err := os.MkdirAll(filepath.Dir(m.path), os.FileMode(0755)); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+func (m *ApplyManifest) Path() string {
+	return m.path
+}
+
+func LoadApplyManifest(path string) (*ApplyManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &ApplyManifest{path: path}
+	return m, json.Unmarshal(data, m)
+}
+
+// The most recent apply that has not been rolled back. Directories without a manifest are applies that failed before
+// replacing anything and are skipped.
+func LatestApplyManifest(rollbackDir string) (*ApplyManifest, error) {
+	dirs, _ := ioutil.ReadDir(rollbackDir)
+	var latest *ApplyManifest
+	for _, dir := range dirs {
+		m, err := LoadApplyManifest(filepath.Join(rollbackDir, dir.Name(), "manifest.json"))
+		if err == nil && m.RolledBack == nil && (latest == nil || m.Applied.After(latest.Applied)) {
+			latest = m
+		}
+	}
+	if latest == nil {
+		return nil, errors.New("nothing to roll back in " + rollbackDir)
+	}
+	return latest, nil
+}
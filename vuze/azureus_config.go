@@ -0,0 +1,46 @@
+package vuze
+
+import (
+	"errors"
+	"fmt"
+	"github.com/blaize9/vuze-tools/utils"
+	"io/ioutil"
+)
+
+// Settings of azureus.config used by vuze-tools
+const (
+	SettingDefaultSavePath = "Default save path"
+)
+
+// AzureusConfig is a decoded azureus.config, every Vuze setting by its name
+type AzureusConfig struct {
+	Settings map[string]interface{}
+}
+
+func LoadAzureusConfig(path string) (*AzureusConfig, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open vuze azureus.config [%v]", err)
+	}
+	return UnmarshalAzureusConfig(file)
+}
+
+func UnmarshalAzureusConfig(data []byte) (*AzureusConfig, error) {
+	decoded, err := utils.BencodeUnmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal vuze azureus.config [%v]", err)
+	}
+	settings := bencodeMap(decoded)
+	if settings == nil {
+		return nil, errors.New("azureus.config is not a dictionary")
+	}
+	return &AzureusConfig{Settings: settings}, nil
+}
+
+func (c *AzureusConfig) String(key string) string {
+	return bencodeString(c.Settings[key])
+}
+
+func (c *AzureusConfig) Int(key string) int64 {
+	return bencodeInt(c.Settings[key])
+}
@@ -0,0 +1,45 @@
+package vuze
+
+// Helpers for reading values decoded by bencode.Unmarshal without panicking on unexpected types
+
+func bencodeString(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return ""
+}
+
+func bencodeInt(v interface{}) int64 {
+	if i, ok := v.(int64); ok {
+		return i
+	}
+	return 0
+}
+
+func bencodeMap(v interface{}) map[string]interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m
+	}
+	return nil
+}
+
+func bencodeList(v interface{}) []interface{} {
+	if l, ok := v.([]interface{}); ok {
+		return l
+	}
+	return nil
+}
+
+func bencodeStringList(v interface{}) (list []string) {
+	for _, s := range bencodeList(v) {
+		list = append(list, bencodeString(s))
+	}
+	return list
+}
+
+func bencodeIntList(v interface{}) (list []int64) {
+	for _, i := range bencodeList(v) {
+		list = append(list, bencodeInt(i))
+	}
+	return list
+}
@@ -0,0 +1,60 @@
+package vuze
+
+import (
+	"errors"
+	"fmt"
+	"github.com/blaize9/vuze-tools/utils"
+	"io/ioutil"
+)
+
+// Category is a user category of categories.config, downloads refer to it by name
+type Category struct {
+	Name        string `json:"name"`
+	MaxUpload   int64  `json:"max_upload"`
+	MaxDownload int64  `json:"max_download"`
+}
+
+type CategoriesConfig struct {
+	Categories []Category
+}
+
+func LoadCategoriesConfig(path string) (*CategoriesConfig, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open vuze categories.config [%v]", err)
+	}
+	return UnmarshalCategoriesConfig(file)
+}
+
+func UnmarshalCategoriesConfig(data []byte) (*CategoriesConfig, error) {
+	decoded, err := utils.BencodeUnmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal vuze categories.config [%v]", err)
+	}
+	datam := bencodeMap(decoded)
+	if datam == nil {
+		return nil, errors.New("categories.config is not a dictionary")
+	}
+	cc := &CategoriesConfig{}
+	for _, c := range bencodeList(datam["categories"]) {
+		category := bencodeMap(c)
+		if category == nil {
+			return nil, errors.New("categories.config category is not a dictionary")
+		}
+		cc.Categories = append(cc.Categories, Category{
+			Name:        bencodeString(category["name"]),
+			MaxUpload:   bencodeInt(category["maxup"]),
+			MaxDownload: bencodeInt(category["maxdown"]),
+		})
+	}
+	return cc, nil
+}
+
+func (cc *CategoriesConfig) Has(name string) bool {
+	for _, c := range cc.Categories {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
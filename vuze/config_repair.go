@@ -0,0 +1,186 @@
+package vuze
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/blaize9/vuze-tools/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Variants Vuze leaves next to a file while saving it, the file itself first
+var FileVariants = []string{"", ".bak", "._AZ", ".saving"}
+
+// A candidate is only chosen over older ones when it has at least this share of the most entries seen,
+// so a newer file that lost most of its downloads does not win over an older complete one
+const configEntriesTolerance = 0.9
+
+// ConfigCandidate is one copy of a root level .config file, in the Azureus directory or a dated backup
+type ConfigCandidate struct {
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"mod_time"`
+	Valid   bool      `json:"valid"`
+	Entries int       `json:"entries"`
+	Error   string    `json:"error,omitempty"`
+	sum     [sha256.Size]byte
+}
+
+// Names of the root level .config files of dir, without variant suffixes
+func ConfigFileNames(dir string) (names []string) {
+	files, _ := ioutil.ReadDir(dir)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		name := file.Name()
+		for _, variant := range FileVariants[1:] {
+			name = strings.TrimSuffix(name, variant)
+		}
+		if filepath.Ext(name) == ".config" && !utils.SliceContains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Evaluates every variant of name in dirs
+func FindConfigCandidates(name string, dirs []string) (candidates []ConfigCandidate) {
+	for _, dir := range dirs {
+		for _, variant := range FileVariants {
+			path := filepath.Join(dir, name+variant)
+			if utils.FileExists(path) {
+				candidates = append(candidates, EvaluateConfigCandidate(path))
+			}
+		}
+	}
+	return candidates
+}
+
+// A candidate is valid when its typed reader, or for unknown files the bencode decoder, accepts it. Entries are the
+// downloads, categories, tags or settings of the known files and the top level keys of any other .config file.
+func EvaluateConfigCandidate(path string) ConfigCandidate {
+	candidate := ConfigCandidate{Path: path}
+	stat, err := os.Stat(path)
+	if err != nil {
+		candidate.Error = err.Error()
+		return candidate
+	}
+	candidate.ModTime = stat.ModTime()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		candidate.Error = err.Error()
+		return candidate
+	}
+	candidate.sum = sha256.Sum256(data)
+	name := filepath.Base(path)
+	for _, variant := range FileVariants[1:] {
+		name = strings.TrimSuffix(name, variant)
+	}
+	switch name {
+	case "downloads.config":
+		dc, err := UnmarshalDownloadsConfig(data)
+		if err == nil {
+			candidate.Entries = len(dc.Downloads)
+		}
+		return candidate.result(err)
+	case "categories.config":
+		cc, err := UnmarshalCategoriesConfig(data)
+		if err == nil {
+			candidate.Entries = len(cc.Categories)
+		}
+		return candidate.result(err)
+	case "tag.config":
+		tc, err := UnmarshalTagsConfig(data)
+		if err == nil {
+			candidate.Entries = len(tc.Tags)
+		}
+		return candidate.result(err)
+	case "azureus.config":
+		ac, err := UnmarshalAzureusConfig(data)
+		if err == nil {
+			candidate.Entries = len(ac.Settings)
+		}
+		return candidate.result(err)
+	}
+
+	decoded, err := utils.BencodeUnmarshal(data)
+	if err != nil {
+		candidate.Error = err.Error()
+		return candidate
+	}
+	datam := bencodeMap(decoded)
+	if datam == nil {
+		candidate.Error = "not a dictionary"
+		return candidate
+	}
+	candidate.Valid = true
+	candidate.Entries = len(datam)
+	return candidate
+}
+
+func (c ConfigCandidate) result(err error) ConfigCandidate {
+	c.Valid = err == nil
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// Whether both candidates are valid and have the same bytes
+func (c ConfigCandidate) SameContent(other ConfigCandidate) bool {
+	return c.Valid && other.Valid && c.sum == other.sum
+}
+
+// Keeps the current file at path current when it is valid and has nearly as many entries as the most complete candidate,
+// otherwise picks the newest valid candidate that does. Also explains why the candidate was chosen over the others.
+func BestConfigCandidate(candidates []ConfigCandidate, current string) (best ConfigCandidate, reason string, ok bool) {
+	maxEntries := 0
+	var valid []ConfigCandidate
+	var currentCandidate *ConfigCandidate
+	for i, c := range candidates {
+		if c.Path == current {
+			currentCandidate = &candidates[i]
+		}
+		if c.Valid {
+			valid = append(valid, c)
+			if c.Entries > maxEntries {
+				maxEntries = c.Entries
+			}
+		}
+	}
+	if len(valid) == 0 {
+		return best, fmt.Sprintf("none of the %d candidates is valid", len(candidates)), false
+	}
+	withinTolerance := func(c ConfigCandidate) bool {
+		return float64(c.Entries) >= float64(maxEntries)*configEntriesTolerance
+	}
+	if currentCandidate != nil && currentCandidate.Valid && withinTolerance(*currentCandidate) {
+		return *currentCandidate, fmt.Sprintf("the current file is valid with %d of at most %d entries", currentCandidate.Entries, maxEntries), true
+	}
+
+	sort.SliceStable(valid, func(i, j int) bool {
+		return valid[i].ModTime.After(valid[j].ModTime)
+	})
+	var skipped []string
+	for _, c := range valid {
+		if withinTolerance(c) {
+			best = c
+			break
+		}
+		skipped = append(skipped, fmt.Sprintf("%s only has %d entries", c.Path, c.Entries))
+	}
+	reason = fmt.Sprintf("newest valid candidate with %d of at most %d entries (%d valid)", best.Entries, maxEntries, len(valid))
+	if invalid := len(candidates) - len(valid); invalid > 0 {
+		reason += fmt.Sprintf(", %d invalid", invalid)
+	}
+	if len(skipped) > 0 {
+		reason += ", newer " + strings.Join(skipped, ", ")
+	}
+	return best, reason, true
+}
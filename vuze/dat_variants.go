@@ -0,0 +1,165 @@
+package vuze
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DatVariant is one copy of active/<hash>.dat and what it holds
+type DatVariant struct {
+	Suffix      string     `json:"suffix"`
+	Path        string     `json:"path"`
+	Size        int64      `json:"size"`
+	ModTime     time.Time  `json:"mod_time"`
+	Valid       bool       `json:"valid"`
+	HashMatches bool       `json:"hash_matches"`
+	Error       string     `json:"error,omitempty"`
+	Dat         *ActiveDat `json:"-"`
+	sum         [sha256.Size]byte
+}
+
+// Parses every existing variant of active/<hash>.dat, in the order Vuze falls back to them
+func LoadDatVariants(activeDir string, hash string) (variants []DatVariant) {
+	for _, suffix := range activeDatVariants {
+		path := filepath.Join(activeDir, hash+suffix)
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		v := DatVariant{Suffix: suffix, Path: path, Size: stat.Size(), ModTime: stat.ModTime()}
+		if data, err := ioutil.ReadFile(path); err == nil {
+			v.sum = sha256.Sum256(data)
+		}
+		dat, err := LoadActiveDat(path)
+		switch {
+		case err != nil:
+			v.Error = err.Error()
+		case dat.Info == nil:
+			v.Error = "no info dictionary"
+		default:
+			v.Valid = true
+			v.Dat = dat
+			hashes, err := dat.InfoHashes()
+			if err == nil {
+				err = checkActiveHash(path, hashes)
+			}
+			v.HashMatches = err == nil
+			if err != nil {
+				v.Error = err.Error()
+			}
+		}
+		variants = append(variants, v)
+	}
+	return variants
+}
+
+func (v DatVariant) usable() bool {
+	return v.Valid && v.HashMatches
+}
+
+// Compares two usable variants: valid resume data, completed pieces, statistics counters (which only grow) and modification time
+// to the second, as Vuze writes .dat and .dat.bak moments apart. Returns what decided it, or "" when they are equal.
+func compareDatVariants(a DatVariant, b DatVariant) (better bool, reason string) {
+	if a.sum == b.sum {
+		return false, ""
+	}
+	ra, rb := a.Dat.Resume, b.Dat.Resume
+	sa, sb := a.Dat.Stats, b.Dat.Stats
+	switch {
+	case ra.Valid != rb.Valid:
+		return ra.Valid, "resume data is valid"
+	case ra.CompletedPieces() != rb.CompletedPieces():
+		return ra.CompletedPieces() > rb.CompletedPieces(), fmt.Sprintf("%d instead of %d pieces done", ra.CompletedPieces(), rb.CompletedPieces())
+	case sa.Downloaded+sa.Uploaded != sb.Downloaded+sb.Uploaded:
+		return sa.Downloaded+sa.Uploaded > sb.Downloaded+sb.Uploaded, fmt.Sprintf("%d instead of %d bytes transferred", sa.Downloaded+sa.Uploaded, sb.Downloaded+sb.Uploaded)
+	case sa.SecondsDownloading+sa.SecondsOnlySeeding != sb.SecondsDownloading+sb.SecondsOnlySeeding:
+		return sa.SecondsDownloading+sa.SecondsOnlySeeding > sb.SecondsDownloading+sb.SecondsOnlySeeding, "active for longer"
+	case a.ModTime.Unix() != b.ModTime.Unix():
+		return a.ModTime.After(b.ModTime), fmt.Sprintf("modified %s instead of %s", a.ModTime.Format(time.RFC3339), b.ModTime.Format(time.RFC3339))
+	}
+	return false, ""
+}
+
+// Picks the most complete and most recent variant whose info hash matches the file name, and explains why
+func BestDatVariant(variants []DatVariant) (best DatVariant, reason string, ok bool) {
+	var usable []DatVariant
+	var rejected []string
+	for _, v := range variants {
+		if v.usable() {
+			usable = append(usable, v)
+		} else {
+			rejected = append(rejected, fmt.Sprintf("%s %s", v.Suffix, v.Error))
+		}
+	}
+	if len(usable) == 0 {
+		return best, fmt.Sprintf("no usable variant of %d", len(variants)), false
+	}
+	// Stable, so equal variants keep the order Vuze falls back to them
+	sort.SliceStable(usable, func(i, j int) bool {
+		better, _ := compareDatVariants(usable[i], usable[j])
+		return better
+	})
+	best = usable[0]
+
+	var reasons []string
+	for _, other := range usable[1:] {
+		if _, why := compareDatVariants(best, other); why != "" {
+			reasons = append(reasons, fmt.Sprintf("over %s: %s", other.Suffix, why))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("same as %s", other.Suffix))
+		}
+	}
+	reason = best.Suffix
+	if len(reasons) > 0 {
+		reason += " " + strings.Join(reasons, ", ")
+	}
+	if len(rejected) > 0 {
+		reason += "; rejected " + strings.Join(rejected, ", ")
+	}
+	return best, reason, true
+}
+
+// Values of a variant that can differ between variants, always the same fields in the same order.
+// Values read from the active file are empty when it could not be parsed.
+func DatVariantFields(v DatVariant) [][2]string {
+	d := v.Dat
+	if d == nil {
+		d = &ActiveDat{}
+	}
+	fields := [][2]string{
+		{"size", fmt.Sprint(v.Size)},
+		{"modified", v.ModTime.Format(time.RFC3339)},
+		{"valid", fmt.Sprint(v.Valid)},
+		{"hash matches", fmt.Sprint(v.HashMatches)},
+		{"resume valid", fmt.Sprint(d.Resume.Valid)},
+		{"pieces done", fmt.Sprintf("%d/%d", d.Resume.CompletedPieces(), len(d.Resume.PieceStates))},
+		{"partial pieces", fmt.Sprint(len(d.Resume.PartialPieces))},
+		{"downloaded", fmt.Sprint(d.Stats.Downloaded)},
+		{"uploaded", fmt.Sprint(d.Stats.Uploaded)},
+		{"completed", fmt.Sprint(d.Stats.Completed)},
+		{"discarded", fmt.Sprint(d.Stats.Discarded)},
+		{"hash fail bytes", fmt.Sprint(d.Stats.HashFailBytes)},
+		{"seconds downloading", fmt.Sprint(d.Stats.SecondsDownloading)},
+		{"seconds seeding", fmt.Sprint(d.Stats.SecondsOnlySeeding)},
+		{"category", d.Attributes.Category},
+		{"relative path", d.Attributes.RelativePath},
+		{"file links", fmt.Sprint(d.Attributes.FileLinks)},
+		{"file priorities", fmt.Sprint(d.Attributes.FilePriorities)},
+		{"announce", d.Announce},
+		{"tracker cache", fmt.Sprint(len(d.TrackerCache))},
+		{"peer cache", fmt.Sprint(len(d.Peers))},
+		{"error", v.Error},
+	}
+	if v.Dat == nil {
+		for i := 4; i < len(fields)-1; i++ {
+			fields[i][1] = ""
+		}
+	}
+	return fields
+}
@@ -0,0 +1,274 @@
+package vuze
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/zeebo/bencode"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Download states stored in downloads.config
+const (
+	DownloadStateWaiting     = 0
+	DownloadStateDownloading = 50
+	DownloadStateSeeding     = 60
+	DownloadStateStopped     = 70
+	DownloadStateQueued      = 75
+	DownloadStateError       = 100
+)
+
+// Keys of a downloads.config download
+const (
+	keyTorrent            = "torrent"
+	keyTorrentHash        = "torrent_hash"
+	keySaveDir            = "save_dir"
+	keySaveDirUTF8        = "save_dir_utf8"
+	keySaveFile           = "save_file"
+	keySaveFileUTF8       = "save_file_utf8"
+	keyState              = "state"
+	keyPosition           = "position"
+	keyCategory           = "category"
+	keyCreationTime       = "creationTime"
+	keyPersistent         = "persistent"
+	keyForceStart         = "forceStart"
+	keyDownloaded         = "downloaded"
+	keyUploaded           = "uploaded"
+	keyCompleted          = "completed"
+	keyDiscarded          = "discarded"
+	keyHashFailBytes      = "hashfailbytes"
+	keySecondsDownloading = "secondsDownloading"
+	keySecondsOnlySeeding = "secondsOnlySeeding"
+	keyMaxDownload        = "maxdl"
+	keyMaxUpload          = "maxul"
+	keyUploads            = "uploads"
+	keyFilePriorities     = "file_priorities"
+)
+
+// DownloadsConfig is a decoded downloads.config. Keys that are not modelled are kept and written back untouched.
+type DownloadsConfig struct {
+	Downloads []*Download
+	raw       map[string]interface{}
+}
+
+type Download struct {
+	Torrent            string
+	TorrentHash        []byte
+	SaveDir            string
+	SaveFile           string
+	State              int64
+	Position           int64
+	Category           string
+	CreationTime       int64
+	Persistent         int64
+	ForceStart         int64
+	Downloaded         int64
+	Uploaded           int64
+	Completed          int64
+	Discarded          int64
+	HashFailBytes      int64
+	SecondsDownloading int64
+	SecondsOnlySeeding int64
+	MaxDownload        int64
+	MaxUpload          int64
+	Uploads            int64
+	FilePriorities     []int64
+	raw                map[string]interface{}
+}
+
+func NewDownloadsConfig() *DownloadsConfig {
+	return &DownloadsConfig{raw: map[string]interface{}{}}
+}
+
+func LoadDownloadsConfig(path string) (*DownloadsConfig, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open vuze downloads config [%v]", err)
+	}
+	return UnmarshalDownloadsConfig(file)
+}
+
+func UnmarshalDownloadsConfig(data []byte) (*DownloadsConfig, error) {
+	decoded, err := utils.BencodeUnmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal vuze downloads config [%v]", err)
+	}
+	datam, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("downloads.config is not a dictionary")
+	}
+
+	dc := &DownloadsConfig{raw: datam}
+	if _, ok := datam["downloads"]; !ok {
+		return dc, nil
+	}
+	downloads, ok := datam["downloads"].([]interface{})
+	if !ok {
+		return nil, errors.New("downloads.config downloads is not a list")
+	}
+	for i, v := range downloads {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("downloads.config download %d is not a dictionary", i)
+		}
+		dc.Downloads = append(dc.Downloads, newDownloadFromMap(m))
+	}
+	return dc, nil
+}
+
+func (dc *DownloadsConfig) Marshal() ([]byte, error) {
+	datam := map[string]interface{}{}
+	for k, v := range dc.raw {
+		datam[k] = v
+	}
+	downloads := make([]interface{}, 0, len(dc.Downloads))
+	for _, d := range dc.Downloads {
+		downloads = append(downloads, d.toMap())
+	}
+	datam["downloads"] = downloads
+	return bencode.EncodeBytes(datam)
+}
+
+// Numbers the queue positions of the downloads 1, 2, 3... in their current order
+func (dc *DownloadsConfig) RenumberPositions() {
+	for i, d := range dc.Downloads {
+		d.Position = int64(i + 1)
+	}
+}
+
+func (dc *DownloadsConfig) Save(path string) error {
+	data, err := dc.Marshal()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Upper case hex info hash, the same as the active/<HASH>.dat filename
+func (d *Download) Hash() string {
+	return strings.ToUpper(hex.EncodeToString(d.TorrentHash))
+}
+
+// Builds a download from what an active file knows about it, for downloads that have no downloads.config entry left.
+// The save location is only known when the relative path attribute is absolute, otherwise saveDir is used.
+func NewDownloadFromActive(dat *ActiveDat, saveDir string) *Download {
+	d := &Download{raw: map[string]interface{}{}}
+	d.TorrentHash, _ = hex.DecodeString(dat.Hash)
+	d.SaveDir = saveDir
+	if dat.Info != nil {
+		d.SaveFile = dat.Info.Name
+	}
+	if filepath.IsAbs(dat.Attributes.RelativePath) {
+		d.SaveDir, d.SaveFile = filepath.Split(filepath.Clean(dat.Attributes.RelativePath))
+		d.SaveDir = filepath.Clean(d.SaveDir)
+	}
+	d.State = DownloadStateStopped
+	d.Category = dat.Attributes.Category
+	d.Persistent = 1
+	d.Downloaded = dat.Stats.Downloaded
+	d.Uploaded = dat.Stats.Uploaded
+	d.Completed = dat.Stats.Completed
+	d.Discarded = dat.Stats.Discarded
+	d.HashFailBytes = dat.Stats.HashFailBytes
+	d.SecondsDownloading = dat.Stats.SecondsDownloading
+	d.SecondsOnlySeeding = dat.Stats.SecondsOnlySeeding
+	d.FilePriorities = dat.Attributes.FilePriorities
+	return d
+}
+
+func newDownloadFromMap(m map[string]interface{}) *Download {
+	d := &Download{raw: m}
+	d.Torrent = bencodeString(m[keyTorrent])
+	d.TorrentHash, _ = m[keyTorrentHash].([]byte)
+	d.SaveDir = bencodeString(m[keySaveDir])
+	if utf8, ok := m[keySaveDirUTF8]; ok {
+		d.SaveDir = bencodeString(utf8)
+	}
+	d.SaveFile = bencodeString(m[keySaveFile])
+	if utf8, ok := m[keySaveFileUTF8]; ok {
+		d.SaveFile = bencodeString(utf8)
+	}
+	d.State = bencodeInt(m[keyState])
+	d.Position = bencodeInt(m[keyPosition])
+	d.Category = bencodeString(m[keyCategory])
+	d.CreationTime = bencodeInt(m[keyCreationTime])
+	d.Persistent = bencodeInt(m[keyPersistent])
+	d.ForceStart = bencodeInt(m[keyForceStart])
+	d.Downloaded = bencodeInt(m[keyDownloaded])
+	d.Uploaded = bencodeInt(m[keyUploaded])
+	d.Completed = bencodeInt(m[keyCompleted])
+	d.Discarded = bencodeInt(m[keyDiscarded])
+	d.HashFailBytes = bencodeInt(m[keyHashFailBytes])
+	d.SecondsDownloading = bencodeInt(m[keySecondsDownloading])
+	d.SecondsOnlySeeding = bencodeInt(m[keySecondsOnlySeeding])
+	d.MaxDownload = bencodeInt(m[keyMaxDownload])
+	d.MaxUpload = bencodeInt(m[keyMaxUpload])
+	d.Uploads = bencodeInt(m[keyUploads])
+	d.FilePriorities = bencodeIntList(m[keyFilePriorities])
+	return d
+}
+
+// Only fields that differ from the original map are written so untouched downloads round-trip unchanged
+func (d *Download) toMap() map[string]interface{} {
+	orig := newDownloadFromMap(d.raw)
+	m := map[string]interface{}{}
+	for k, v := range d.raw {
+		m[k] = v
+	}
+	setString := func(key string, value string, origValue string) {
+		if value != origValue {
+			m[key] = []byte(value)
+		}
+	}
+	setInt := func(key string, value int64, origValue int64) {
+		if value != origValue {
+			m[key] = value
+		}
+	}
+
+	setString(keyTorrent, d.Torrent, orig.Torrent)
+	if !bytes.Equal(d.TorrentHash, orig.TorrentHash) {
+		m[keyTorrentHash] = d.TorrentHash
+	}
+	if d.SaveDir != orig.SaveDir {
+		m[keySaveDir] = []byte(d.SaveDir)
+		if _, ok := m[keySaveDirUTF8]; ok {
+			m[keySaveDirUTF8] = []byte(d.SaveDir)
+		}
+	}
+	if d.SaveFile != orig.SaveFile {
+		m[keySaveFile] = []byte(d.SaveFile)
+		if _, ok := m[keySaveFileUTF8]; ok {
+			m[keySaveFileUTF8] = []byte(d.SaveFile)
+		}
+	}
+	setInt(keyState, d.State, orig.State)
+	setInt(keyPosition, d.Position, orig.Position)
+	setString(keyCategory, d.Category, orig.Category)
+	setInt(keyCreationTime, d.CreationTime, orig.CreationTime)
+	setInt(keyPersistent, d.Persistent, orig.Persistent)
+	setInt(keyForceStart, d.ForceStart, orig.ForceStart)
+	setInt(keyDownloaded, d.Downloaded, orig.Downloaded)
+	setInt(keyUploaded, d.Uploaded, orig.Uploaded)
+	setInt(keyCompleted, d.Completed, orig.Completed)
+	setInt(keyDiscarded, d.Discarded, orig.Discarded)
+	setInt(keyHashFailBytes, d.HashFailBytes, orig.HashFailBytes)
+	setInt(keySecondsDownloading, d.SecondsDownloading, orig.SecondsDownloading)
+	setInt(keySecondsOnlySeeding, d.SecondsOnlySeeding, orig.SecondsOnlySeeding)
+	setInt(keyMaxDownload, d.MaxDownload, orig.MaxDownload)
+	setInt(keyMaxUpload, d.MaxUpload, orig.MaxUpload)
+	setInt(keyUploads, d.Uploads, orig.Uploads)
+	if !reflect.DeepEqual(d.FilePriorities, orig.FilePriorities) {
+		priorities := make([]interface{}, 0, len(d.FilePriorities))
+		for _, p := range d.FilePriorities {
+			priorities = append(priorities, p)
+		}
+		m[keyFilePriorities] = priorities
+	}
+	return m
+}
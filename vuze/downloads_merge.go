@@ -0,0 +1,102 @@
+package vuze
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Policies for downloads listed in more than one downloads.config
+const (
+	MergeNewest  = "newest"  // the entry of the most recently modified file wins
+	MergeCurrent = "current" // the entry of the current downloads.config wins, then the newest
+	MergeUnion   = "union"   // the newest entry wins, keys it lacks are taken from older entries
+)
+
+var MergePolicies = []string{MergeNewest, MergeCurrent, MergeUnion}
+
+// DownloadsConfigSource is a readable downloads.config taking part in a merge
+type DownloadsConfigSource struct {
+	Path    string
+	ModTime time.Time
+	Current bool
+	Config  *DownloadsConfig
+}
+
+// MergedDownload is a download of the merged downloads.config and every file that listed it
+type MergedDownload struct {
+	Download  *Download
+	Source    string
+	Sources   []string
+	InCurrent bool
+}
+
+func LoadDownloadsConfigSource(path string, current bool) (DownloadsConfigSource, error) {
+	source := DownloadsConfigSource{Path: path, Current: current}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return source, err
+	}
+	source.ModTime = stat.ModTime()
+	source.Config, err = LoadDownloadsConfig(path)
+	return source, err
+}
+
+// Unions the downloads of every source, deduplicated by torrent_hash (or torrent path when there is no hash)
+func MergeDownloadsConfigs(sources []DownloadsConfigSource, policy string) ([]*MergedDownload, error) {
+	ordered := append([]DownloadsConfigSource{}, sources...)
+	switch policy {
+	case MergeNewest, MergeUnion:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].ModTime.After(ordered[j].ModTime)
+		})
+	case MergeCurrent:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			if ordered[i].Current != ordered[j].Current {
+				return ordered[i].Current
+			}
+			return ordered[i].ModTime.After(ordered[j].ModTime)
+		})
+	default:
+		return nil, fmt.Errorf("unknown merge policy %q, use one of %v", policy, MergePolicies)
+	}
+
+	var merged []*MergedDownload
+	byKey := map[string]*MergedDownload{}
+	for _, source := range ordered {
+		for _, download := range source.Config.Downloads {
+			key := download.Hash()
+			if key == "" {
+				key = "torrent:" + download.Torrent
+			}
+			m, ok := byKey[key]
+			if !ok {
+				m = &MergedDownload{Download: download, Source: source.Path}
+				byKey[key] = m
+				merged = append(merged, m)
+			} else if policy == MergeUnion {
+				m.Download = mergeDownloads(m.Download, download)
+			}
+			m.Sources = append(m.Sources, source.Path)
+			m.InCurrent = m.InCurrent || source.Current
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Download.Position < merged[j].Download.Position
+	})
+	return merged, nil
+}
+
+// Keys missing from preferred are copied from other
+func mergeDownloads(preferred *Download, other *Download) *Download {
+	m := map[string]interface{}{}
+	for k, v := range other.raw {
+		m[k] = v
+	}
+	for k, v := range preferred.toMap() {
+		m[k] = v
+	}
+	return newDownloadFromMap(m)
+}
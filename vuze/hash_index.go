@@ -0,0 +1,218 @@
+package vuze
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/blaize9/vuze-tools/config"
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/blaize9/vuze-tools/utils/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version of the hash index file format, bump it and add a migration when IndexedFile changes
+const HashIndexVersion = 1
+
+// Changed files parsed before the index is written to disk during a scan
+const hashIndexCheckpointFiles = 500
+
+// HashIndex is the on-disk index of every torrent file in the backups, keyed by file path.
+// Files whose size and modification time did not change since they were indexed are not parsed again.
+type HashIndex struct {
+	Version      int                    `json:"version"`
+	LastModified time.Time              `json:"last_modified"`
+	Files        map[string]IndexedFile `json:"files"`
+	path         string
+	changed      int
+	mutex        sync.Mutex
+	// Held while a snapshot is written, and when the last written one was taken
+	writeMutex sync.Mutex
+	written    time.Time
+}
+
+// Fingerprint and info hashes of an indexed torrent file, Hashes is empty for files that are not valid torrents
+type IndexedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hashes  []string  `json:"hashes,omitempty"`
+}
+
+func GetHashIndexPath() string {
+	return filepath.Join(config.GetAzRecoverPath(), "hashindex.json")
+}
+
+// Path of the gob dump written by earlier versions. Its hashes came from re-encoded info dicts and have no v2 keys,
+// so it is ignored and every backup is hashed again.
+func GetHashStoragePath() string {
+	return filepath.Join(config.GetAzRecoverPath(), "hashstorage.struct")
+}
+
+// Loads the hash index, an empty one when there is no index yet
+func LoadHashIndex(path string) (*HashIndex, error) {
+	index := &HashIndex{Version: HashIndexVersion, Files: map[string]IndexedFile{}, path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if utils.FileExists(GetHashStoragePath()) {
+			log.Infof("Ignoring %s written by an older version, every backup is hashed again", GetHashStoragePath())
+		}
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("Unable to read hash index %s [%v]", path, err)
+	}
+	if index.Version > HashIndexVersion {
+		return nil, fmt.Errorf("hash index %s is version %d, this version of vuze-tools only reads up to %d", path, index.Version, HashIndexVersion)
+	}
+	if index.Files == nil {
+		index.Files = map[string]IndexedFile{}
+	}
+	index.path = path
+	index.Version = HashIndexVersion
+	return index, nil
+}
+
+// Writes the index to a temporary file and renames it so an interrupted save never corrupts it
+func (index *HashIndex) Save() error {
+	index.mutex.Lock()
+	snapshot := index.snapshot()
+	index.mutex.Unlock()
+	return index.write(snapshot)
+}
+
+// What is written to disk, a copy of the index taken under its mutex
+type hashIndexFile struct {
+	Version      int                    `json:"version"`
+	LastModified time.Time              `json:"last_modified"`
+	Files        map[string]IndexedFile `json:"files"`
+}
+
+func (index *HashIndex) snapshot() *hashIndexFile {
+	index.changed = 0
+	index.LastModified = time.Now()
+	files := make(map[string]IndexedFile, len(index.Files))
+	for path, file := range index.Files {
+		files[path] = file
+	}
+	return &hashIndexFile{Version: index.Version, LastModified: index.LastModified, Files: files}
+}
+
+// Marshals and writes a snapshot without holding the index mutex, so the workers keep indexing meanwhile.
+// Snapshots older than the one already written are skipped.
+func (index *HashIndex) write(snapshot *hashIndexFile) error {
+	index.writeMutex.Lock()
+	defer index.writeMutex.Unlock()
+	if config.Get().DryRun || snapshot.LastModified.Before(index.written) {
+		return nil
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmp := index.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, index.path); err != nil {
+		return err
+	}
+	index.written = snapshot.LastModified
+	return nil
+}
+
+// Lists the .torrent files of a backup torrents directory
+func listTorrentFiles(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	torrents := files[:0]
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".torrent" {
+			torrents = append(torrents, file)
+		}
+	}
+	return torrents, nil
+}
+
+// Parses a torrent file unless it is already indexed with the same size and modification time
+func (index *HashIndex) IndexFile(path string, file os.FileInfo) (parsed bool) {
+	index.mutex.Lock()
+	indexed, ok := index.Files[path]
+	index.mutex.Unlock()
+	if ok && indexed.Size == file.Size() && indexed.ModTime.Equal(file.ModTime()) {
+		return false
+	}
+
+	indexed = IndexedFile{Size: file.Size(), ModTime: file.ModTime()}
+	// v2 and hybrid torrents are indexed under every hash they can be looked up by
+	if hashes, err := utils.GetInfoHashesFromFile(path); err == nil {
+		indexed.Hashes = hashes.Keys()
+	}
+	index.set(path, indexed)
+	return true
+}
+
+// Drops indexed files of dir that are no longer there
+func (index *HashIndex) Prune(dir string, files []os.FileInfo) {
+	present := map[string]bool{}
+	for _, file := range files {
+		present[filepath.Join(dir, file.Name())] = true
+	}
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	for path := range index.Files {
+		if filepath.Dir(path) == dir && !present[path] {
+			delete(index.Files, path)
+			index.changed++
+		}
+	}
+}
+
+func (index *HashIndex) set(path string, file IndexedFile) {
+	index.mutex.Lock()
+	index.Files[path] = file
+	index.changed++
+	var snapshot *hashIndexFile
+	if index.changed >= hashIndexCheckpointFiles {
+		snapshot = index.snapshot()
+	}
+	index.mutex.Unlock()
+
+	if snapshot != nil {
+		if err := index.write(snapshot); err != nil {
+			log.Errorf("Error saving hash index checkpoint [%s]", err)
+		}
+	}
+}
+
+// Hash lookup table of the indexed files inside the given directories
+func (index *HashIndex) HashStorage(dirs []string) HashStorage {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	storage := HashStorage{BackupDirectories: dirs, HashMap: HashMap{}, LastModified: index.LastModified}
+	for path, file := range index.Files {
+		if !inDirectories(path, dirs) {
+			continue
+		}
+		for _, hash := range file.Hashes {
+			storage.HashMap[hash] = append(storage.HashMap[hash], Filepath{Filepath: path, DateModified: file.ModTime})
+		}
+	}
+	return storage
+}
+
+func inDirectories(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package vuze
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PathRule moves paths starting with From to To. Paths are matched whatever separator they use and the rest of a
+// rewritten path is converted to the separator of To, so Windows paths can be mapped to Linux ones and back.
+type PathRule struct {
+	From       string
+	To         string
+	IgnoreCase bool
+}
+
+// Parses FROM=TO. Windows paths (a drive letter or backslashes) are matched ignoring case like Windows does.
+func ParsePathRule(rule string, ignoreCase bool) (PathRule, error) {
+	parts := strings.SplitN(rule, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return PathRule{}, fmt.Errorf("invalid path rule %q, use FROM=TO", rule)
+	}
+	return PathRule{From: parts[0], To: parts[1], IgnoreCase: ignoreCase || isWindowsPath(parts[0])}, nil
+}
+
+func isWindowsPath(path string) bool {
+	return strings.Contains(path, `\`) || (len(path) >= 2 && path[1] == ':')
+}
+
+// Separators of path unified to /, without a trailing one
+func slashPath(path string) string {
+	path = strings.Replace(path, `\`, "/", -1)
+	if len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+	}
+	return path
+}
+
+// The rewritten path and whether the rule matched
+func (r PathRule) Rewrite(path string) (string, bool) {
+	from, p := slashPath(r.From), slashPath(path)
+	prefix := p
+	if len(p) > len(from) {
+		prefix = p[:len(from)]
+	}
+	if r.IgnoreCase && !strings.EqualFold(prefix, from) || !r.IgnoreCase && prefix != from {
+		return path, false
+	}
+	rest := p[len(prefix):]
+	if rest != "" && rest[0] != '/' && from != "/" {
+		// Only whole path components match, C:\Users does not match C:\Users2
+		return path, false
+	}
+	to := slashPath(r.To)
+	rewritten := strings.TrimRight(to, "/") + "/" + strings.TrimLeft(rest, "/")
+	if rest == "" {
+		rewritten = to
+	}
+	if isWindowsPath(r.To) && !strings.Contains(r.To, "/") {
+		rewritten = strings.Replace(rewritten, "/", `\`, -1)
+	}
+	return rewritten, true
+}
+
+// PathRewriter applies the first matching rule to a path
+type PathRewriter []PathRule
+
+func (rw PathRewriter) Rewrite(path string) string {
+	if path == "" {
+		return path
+	}
+	for _, rule := range rw {
+		if rewritten, ok := rule.Rewrite(path); ok {
+			return rewritten
+		}
+	}
+	return path
+}
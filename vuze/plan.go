@@ -0,0 +1,104 @@
+package vuze
+
+import (
+	"fmt"
+	"github.com/blaize9/vuze-tools/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// Kinds of actions a recovery can plan
+const (
+	ActionCopy    = "copy"
+	ActionExtract = "extract"
+	ActionRewrite = "rewrite"
+	ActionWrite   = "write"
+	// apply and rollback
+	ActionSnapshot = "snapshot"
+	ActionReplace  = "replace"
+	ActionRemove   = "remove"
+)
+
+type PlannedAction struct {
+	Action      string
+	Hash        string
+	Source      string
+	Destination string
+	Reason      string
+}
+
+// RecoveryPlan records every change a recovery makes. When DryRun is set the changes are only recorded.
+type RecoveryPlan struct {
+	DryRun  bool
+	Actions []PlannedAction
+}
+
+func (p *RecoveryPlan) Add(action PlannedAction) {
+	p.Actions = append(p.Actions, action)
+}
+
+func (p *RecoveryPlan) CopyFile(hash string, src string, dest string, reason string) error {
+	p.Add(PlannedAction{Action: ActionCopy, Hash: hash, Source: src, Destination: dest, Reason: reason})
+	if p.DryRun {
+		return nil
+	}
+	return utils.CopyFile(src, dest)
+}
+
+func (p *RecoveryPlan) SaveTorrentFromActive(hash string, activePath string, dest string) (bool, error) {
+	p.Add(PlannedAction{Action: ActionExtract, Hash: hash, Source: activePath, Destination: dest, Reason: "torrent generated from active file"})
+	if p.DryRun {
+		_, err := TorrentFromActive(activePath)
+		return err == nil, err
+	}
+	return SaveTorrentFromActive(activePath, dest)
+}
+
+func (p *RecoveryPlan) RewriteTorrentPath(hash string, oldPath string, newPath string) {
+	p.RewritePath(hash, oldPath, newPath, "downloads.config torrent path")
+}
+
+func (p *RecoveryPlan) RewritePath(hash string, oldPath string, newPath string, reason string) {
+	p.Add(PlannedAction{Action: ActionRewrite, Hash: hash, Source: oldPath, Destination: newPath, Reason: reason})
+}
+
+func (p *RecoveryPlan) WriteFile(dest string, data []byte, reason string) error {
+	p.Add(PlannedAction{Action: ActionWrite, Destination: dest, Reason: reason})
+	if p.DryRun {
+		return nil
+	}
+	return ioutil.WriteFile(dest, data, 0644)
+}
+
+// Copies src to dest with a temporary file and a rename
+func (p *RecoveryPlan) ReplaceFile(src string, dest string, action string, reason string) error {
+	p.Add(PlannedAction{Action: action, Source: src, Destination: dest, Reason: reason})
+	if p.DryRun {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), os.FileMode(0755)); err != nil {
+		return err
+	}
+	return utils.ReplaceFile(src, dest)
+}
+
+func (p *RecoveryPlan) RemoveFile(dest string, reason string) error {
+	p.Add(PlannedAction{Action: ActionRemove, Destination: dest, Reason: reason})
+	if p.DryRun {
+		return nil
+	}
+	return os.Remove(dest)
+}
+
+func (p *RecoveryPlan) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ACTION\tHASH\tSOURCE\tDESTINATION\tREASON\n")
+	for _, action := range p.Actions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", action.Action, action.Hash, action.Source, action.Destination, action.Reason)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d planned actions\n", len(p.Actions))
+}
@@ -0,0 +1,106 @@
+package vuze
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// Environment variable listing profile directories to check before the usual locations, separated like PATH
+const ProfileEnv = "AZUREUS_USER_PATH"
+
+// Profile is a candidate Azureus/Vuze profile directory
+type Profile struct {
+	Path            string
+	DownloadsConfig string // the downloads.config variant found, empty when there is none
+	Downloads       int
+	HasActive       bool
+	ModTime         time.Time
+}
+
+// A profile needs a downloads.config (or one of its variants) or an active directory, anything less is not worth recovering
+func (p Profile) Valid() bool {
+	return p.DownloadsConfig != "" || p.HasActive
+}
+
+// Directories Vuze stores its profile in: the AZUREUS_USER_PATH override, the per platform locations and Wine prefixes
+func ProfileLocations() (locations []string) {
+	locations = append(locations, filepath.SplitList(os.Getenv(ProfileEnv))...)
+
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "windows":
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			locations = append(locations, filepath.Join(appData, "Azureus"), filepath.Join(appData, "Vuze"))
+		}
+	case "darwin":
+		support := filepath.Join(home, "Library", "Application Support")
+		locations = append(locations, filepath.Join(support, "Vuze"), filepath.Join(support, "Azureus"))
+	}
+	if home != "" {
+		locations = append(locations, filepath.Join(home, ".azureus"), filepath.Join(home, ".vuze"))
+	}
+
+	prefixes := []string{os.Getenv("WINEPREFIX")}
+	if home != "" {
+		prefixes = append(prefixes, filepath.Join(home, ".wine"))
+	}
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			continue
+		}
+		for _, appData := range []string{filepath.Join("AppData", "Roaming"), "Application Data"} {
+			for _, name := range []string{"Azureus", "Vuze"} {
+				matches, _ := filepath.Glob(filepath.Join(prefix, "drive_c", "users", "*", appData, name))
+				locations = append(locations, matches...)
+			}
+		}
+	}
+	return locations
+}
+
+// Checks what dir contains of a Vuze profile
+func InspectProfile(dir string) Profile {
+	p := Profile{Path: dir}
+	if stat, err := os.Stat(filepath.Join(dir, "active")); err == nil && stat.IsDir() {
+		p.HasActive = true
+		p.ModTime = stat.ModTime()
+	}
+	for _, variant := range FileVariants {
+		path := filepath.Join(dir, "downloads.config"+variant)
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		p.DownloadsConfig = path
+		if stat.ModTime().After(p.ModTime) {
+			p.ModTime = stat.ModTime()
+		}
+		if dc, err := LoadDownloadsConfig(path); err == nil {
+			p.Downloads = len(dc.Downloads)
+			break
+		}
+	}
+	return p
+}
+
+// Valid profiles of ProfileLocations, the most recently used first
+func DetectProfiles() (profiles []Profile) {
+	seen := map[string]bool{}
+	for _, location := range ProfileLocations() {
+		resolved, err := filepath.EvalSymlinks(location)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		seen[resolved] = true
+		if p := InspectProfile(location); p.Valid() {
+			profiles = append(profiles, p)
+		}
+	}
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].ModTime.After(profiles[j].ModTime)
+	})
+	return profiles
+}
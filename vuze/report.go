@@ -0,0 +1,131 @@
+package vuze
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Final states of a download in a Report
+const (
+	StateValid         = "valid"
+	StateMissing       = "missing"
+	StateRecovered     = "recovered"
+	StateUnrecoverable = "unrecoverable"
+	StateIncomplete    = "incomplete" // the data on disk misses pieces, set by verify
+)
+
+type ReportEntry struct {
+	Hash       string `json:"info_hash"`
+	Filepath   string `json:"torrent_path"`
+	Found      bool   `json:"found"`
+	Valid      bool   `json:"valid"`
+	Source     string `json:"recovery_source,omitempty"`
+	BackupPath string `json:"backup_path,omitempty"`
+	State      string `json:"state"`
+	Reason     string `json:"reason,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Report lists every download a recovery looked at and what happened to it.
+// Entries are keyed by their downloads.config torrent path, or by hash for active files.
+type Report struct {
+	Mode     string        `json:"mode"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Entries  []ReportEntry `json:"downloads"`
+	index    map[string]int
+}
+
+func NewReport(mode string) *Report {
+	return &Report{Mode: mode, Started: time.Now(), index: map[string]int{}}
+}
+
+func (r *Report) Add(key string, entry ReportEntry) {
+	if i, ok := r.index[key]; ok {
+		r.Entries[i] = entry
+		return
+	}
+	r.index[key] = len(r.Entries)
+	r.Entries = append(r.Entries, entry)
+}
+
+// Adds the downloads from ScanDownloadsConfig that are not in the report yet
+func (r *Report) AddDownloads(torrents []TorrentPathHash) {
+	for _, torrent := range torrents {
+		if _, ok := r.index[torrent.Filepath]; ok {
+			continue
+		}
+		entry := ReportEntry{Hash: strings.ToUpper(hex.EncodeToString(torrent.Hash)), Filepath: torrent.Filepath, Found: torrent.Found, Valid: torrent.Valid, State: StateMissing}
+		if torrent.Found && torrent.Valid {
+			entry.State = StateValid
+		}
+		r.Add(torrent.Filepath, entry)
+	}
+}
+
+func (r *Report) Recovered(key string, source string, backupPath string) {
+	r.update(key, source, backupPath, StateRecovered, nil)
+}
+
+func (r *Report) Unrecoverable(key string, source string, err error) {
+	r.update(key, source, "", StateUnrecoverable, err)
+}
+
+func (r *Report) update(key string, source string, backupPath string, state string, err error) {
+	i, ok := r.index[key]
+	if !ok {
+		r.Add(key, ReportEntry{Filepath: key})
+		i = r.index[key]
+	}
+	entry := &r.Entries[i]
+	entry.Source = source
+	entry.BackupPath = backupPath
+	entry.State = state
+	entry.Error = ""
+	if err != nil {
+		entry.Error = err.Error()
+	}
+}
+
+// Writes the report as <mode>-<time>.json and .csv into dir and returns both paths
+func (r *Report) Save(dir string) (string, string, error) {
+	r.Finished = time.Now()
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return "", "", err
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%s-%s", r.Mode, r.Started.Format("2006-01-02T150405")))
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(base+".json", data, 0644); err != nil {
+		return "", "", err
+	}
+
+	csvFile, err := os.Create(base + ".csv")
+	if err != nil {
+		return "", "", err
+	}
+	defer csvFile.Close()
+
+	w := csv.NewWriter(csvFile)
+	w.Write([]string{"info_hash", "torrent_path", "found", "valid", "recovery_source", "backup_path", "state", "reason", "error"})
+	for _, e := range r.Entries {
+		w.Write([]string{e.Hash, e.Filepath, strconv.FormatBool(e.Found), strconv.FormatBool(e.Valid), e.Source, e.BackupPath, e.State, e.Reason, e.Error})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", "", err
+	}
+
+	return base + ".json", base + ".csv", nil
+}
@@ -0,0 +1,69 @@
+package vuze
+
+import (
+	"fmt"
+	"github.com/mitchellh/go-ps"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files Vuze locks in its directory while it is running
+var vuzeLockFiles = []string{".azlock"}
+
+// Classes and jars on the command line of a Vuze started with java
+var vuzeCommandLineMarkers = []string{"org.gudy.azureus2", "com.aelitis.azureus", "com.biglybt", "azureus2.jar", "vuze.jar"}
+
+// Everything that shows Vuze is running on the profile in azureusDir, empty when it is not.
+// instancePort is the localhost port Vuze listens on to keep a single instance running, 0 skips the probe.
+func DetectRunningVuze(azureusDir string, instancePort int) (detected []string) {
+	for _, name := range vuzeLockFiles {
+		path := filepath.Join(azureusDir, name)
+		if isFileLocked(path) {
+			detected = append(detected, fmt.Sprintf("lock file %s is held", path))
+		}
+	}
+
+	if instancePort > 0 {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(instancePort)), time.Millisecond*500)
+		if err == nil {
+			conn.Close()
+			detected = append(detected, fmt.Sprintf("instance port %d is listening on localhost", instancePort))
+		}
+	}
+
+	processes, _ := ps.Processes()
+	for _, process := range processes {
+		if process.Pid() == os.Getpid() {
+			continue
+		}
+		executable := strings.ToLower(process.Executable())
+		if strings.Contains(executable, "azureus") || executable == "vuze" || executable == "vuze.exe" {
+			detected = append(detected, fmt.Sprintf("process %d %s", process.Pid(), process.Executable()))
+			continue
+		}
+		if strings.HasPrefix(executable, "java") {
+			commandLine := strings.ToLower(processCommandLine(process.Pid()))
+			for _, marker := range vuzeCommandLineMarkers {
+				if strings.Contains(commandLine, marker) {
+					detected = append(detected, fmt.Sprintf("process %d %s running %s", process.Pid(), process.Executable(), marker))
+					break
+				}
+			}
+		}
+	}
+	return detected
+}
+
+// Command line of a process where /proc has it, empty elsewhere
+func processCommandLine(pid int) string {
+	data, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return ""
+	}
+	return strings.Replace(string(data), "\x00", " ", -1)
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package vuze
+
+// Locks held by other processes can not be queried here, the port and process checks still apply
+func isFileLocked(path string) bool {
+	return false
+}
@@ -0,0 +1,83 @@
+package vuze
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/blaize9/vuze-tools/utils"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Variants of an active file in the order Vuze falls back to them
+var activeDatVariants = []string{".dat", ".dat.bak", ".dat._AZ", ".dat.saving"}
+
+// Salvage is the intact part of a corrupt torrent or active file
+type Salvage struct {
+	Path     string
+	Data     []byte
+	Complete []string // top level keys kept byte for byte
+	Partial  string   // top level key kept with only the values before the corruption
+	Corrupt  error    // where decoding stopped
+}
+
+func (s *Salvage) String() string {
+	kept := strings.Join(s.Complete, ", ")
+	if s.Partial != "" {
+		kept += ", part of " + s.Partial
+	}
+	return fmt.Sprintf("%s [kept %s; %v]", s.Path, kept, s.Corrupt)
+}
+
+// Salvages a torrent or active file. It is only usable when its info dictionary survived complete and, when expected
+// is given, hashes to it.
+func SalvageTorrentFile(path string, expected []byte) (*Salvage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Salvage{Path: path}
+	s.Data, s.Complete, s.Partial, s.Corrupt = utils.SalvageBencodeDict(data)
+	if !utils.SliceContains(s.Complete, "info") {
+		return nil, fmt.Errorf("%s: info dictionary is not intact [%v]", path, s.Corrupt)
+	}
+	hashes, err := utils.GetInfoHashes(s.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if expected != nil && !hashes.Matches(expected) {
+		return nil, fmt.Errorf("%s: info hash %s does not match %X", path, hashes, expected)
+	}
+	if dat, err := UnmarshalActiveDat(s.Data); err != nil || dat.Info == nil {
+		return nil, fmt.Errorf("%s: salvaged info dictionary is not a torrent [%v]", path, err)
+	}
+	return s, nil
+}
+
+// Salvages the variant of active/<hash>.dat that kept the most, preferring the variants Vuze falls back to first
+func SalvageActiveDat(activeDir string, hash string) (*Salvage, error) {
+	expected, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an info hash", hash)
+	}
+	var best *Salvage
+	var errs []string
+	for _, variant := range activeDatVariants {
+		path := filepath.Join(activeDir, hash+variant)
+		if !utils.FileExists(path) {
+			continue
+		}
+		s, err := SalvageTorrentFile(path, expected)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if best == nil || len(s.Complete) > len(best.Complete) {
+			best = s
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("nothing to salvage [%s]", strings.Join(errs, "; "))
+	}
+	return best, nil
+}
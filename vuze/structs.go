@@ -25,13 +25,3 @@ type TorrentPathHash struct {
 	Found    bool
 	Valid    bool
 }
-
-type VuzeDat struct {
-	IsDatValid    bool
-	HasAZ         bool
-	IsAZValid     bool
-	HasBak        bool
-	IsBakValid    bool
-	HasSaving     bool
-	IsSavingValid bool
-}
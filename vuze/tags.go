@@ -0,0 +1,83 @@
+package vuze
+
+import (
+	"errors"
+	"fmt"
+	"github.com/blaize9/vuze-tools/utils"
+	"io/ioutil"
+	"sort"
+)
+
+// Tag is a tag of tag.config. Downloads are members by their upper case hex info hash.
+type Tag struct {
+	Type      string   `json:"type"`
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Downloads []string `json:"downloads,omitempty"`
+}
+
+// TagsConfig is a decoded tag.config, which maps tag types to their tags by id
+type TagsConfig struct {
+	Tags []Tag
+}
+
+func LoadTagsConfig(path string) (*TagsConfig, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open vuze tag.config [%v]", err)
+	}
+	return UnmarshalTagsConfig(file)
+}
+
+func UnmarshalTagsConfig(data []byte) (*TagsConfig, error) {
+	decoded, err := utils.BencodeUnmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unmarshal vuze tag.config [%v]", err)
+	}
+	types := bencodeMap(decoded)
+	if types == nil {
+		return nil, errors.New("tag.config is not a dictionary")
+	}
+
+	tc := &TagsConfig{}
+	for _, tagType := range sortedKeys(types) {
+		tags := bencodeMap(types[tagType])
+		for _, id := range sortedKeys(tags) {
+			conf := bencodeMap(tags[id])
+			if conf == nil {
+				continue
+			}
+			tag := Tag{Type: tagType, ID: id, Name: bencodeString(conf["name"])}
+			if tag.Name == "" {
+				tag.Name = bencodeString(conf["n"])
+			}
+			// Manual tags list their downloads by info hash
+			for _, member := range bencodeList(conf["dms"]) {
+				if hash, ok := member.([]byte); ok {
+					tag.Downloads = append(tag.Downloads, utils.HashToString(hash))
+				}
+			}
+			tc.Tags = append(tc.Tags, tag)
+		}
+	}
+	return tc, nil
+}
+
+// Tags the download with the given upper case hex hash is a member of
+func (tc *TagsConfig) TagsOf(hash string) (tags []Tag) {
+	for _, tag := range tc.Tags {
+		if utils.SliceContains(tag.Downloads, hash) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
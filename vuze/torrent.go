@@ -0,0 +1,95 @@
+package vuze
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TorrentInfo is the decoded info dictionary of a torrent
+type TorrentInfo struct {
+	Name        string        `json:"name"`
+	MetaVersion int64         `json:"meta_version"`
+	PieceLength int64         `json:"piece_length"`
+	Pieces      []byte        `json:"-"`
+	Private     bool          `json:"private"`
+	Files       []TorrentFile `json:"files"`
+}
+
+type TorrentFile struct {
+	Path    string `json:"path"`
+	Length  int64  `json:"length"`
+	Padding bool   `json:"padding,omitempty"` // BEP 47 padding files are zeros that are never written to disk
+}
+
+func ParseTorrentInfo(info map[string]interface{}) (*TorrentInfo, error) {
+	if info == nil {
+		return nil, errors.New("torrent has no info dictionary")
+	}
+	t := &TorrentInfo{
+		Name:        bencodeString(info["name"]),
+		PieceLength: bencodeInt(info["piece length"]),
+		MetaVersion: 1,
+		Private:     bencodeInt(info["private"]) == 1,
+	}
+	if version := bencodeInt(info["meta version"]); version != 0 {
+		t.MetaVersion = version
+	}
+	t.Pieces, _ = info["pieces"].([]byte)
+
+	if files, ok := info["files"]; ok {
+		for _, f := range bencodeList(files) {
+			file := bencodeMap(f)
+			t.Files = append(t.Files, TorrentFile{
+				Path:    filepath.Join(append([]string{t.Name}, bencodeStringList(file["path"])...)...),
+				Length:  bencodeInt(file["length"]),
+				Padding: strings.Contains(bencodeString(file["attr"]), "p"),
+			})
+		}
+	} else if _, ok := info["length"]; ok || t.MetaVersion < 2 {
+		t.Files = []TorrentFile{{Path: t.Name, Length: bencodeInt(info["length"])}}
+	} else {
+		// v2 only torrents describe their files in the file tree
+		t.Files = parseFileTree(bencodeMap(info["file tree"]), t.Name)
+		if len(t.Files) == 1 && t.Files[0].Path == filepath.Join(t.Name, t.Name) {
+			t.Files[0].Path = t.Name
+		}
+	}
+	return t, nil
+}
+
+// Walks a v2 file tree, files are dictionaries with an empty key holding the length
+func parseFileTree(tree map[string]interface{}, path string) (files []TorrentFile) {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		node := bencodeMap(tree[name])
+		if leaf := bencodeMap(node[""]); leaf != nil {
+			files = append(files, TorrentFile{Path: filepath.Join(path, name), Length: bencodeInt(leaf["length"])})
+			continue
+		}
+		files = append(files, parseFileTree(node, filepath.Join(path, name))...)
+	}
+	return files
+}
+
+func (t *TorrentInfo) TotalLength() (length int64) {
+	for _, f := range t.Files {
+		length += f.Length
+	}
+	return length
+}
+
+// Number of v1 pieces, v2 only torrents have none
+func (t *TorrentInfo) PieceCount() int {
+	return len(t.Pieces) / 20
+}
+
+// SHA-1 hash of piece i
+func (t *TorrentInfo) PieceHash(i int) []byte {
+	return t.Pieces[i*20 : (i+1)*20]
+}
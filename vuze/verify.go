@@ -0,0 +1,251 @@
+package vuze
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"github.com/blaize9/vuze-tools/utils"
+	"github.com/zeebo/bencode"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Verification is the result of hashing the data of a download against the v1 piece hashes of its torrent
+type Verification struct {
+	Pieces    int      `json:"pieces"`
+	Good      int      `json:"good"`
+	BadPieces []int    `json:"bad_pieces,omitempty"`
+	Missing   []string `json:"missing_files,omitempty"`
+	// PieceDone or PieceNotDone for every piece, as stored in the resume data
+	States []byte `json:"-"`
+}
+
+func (v *Verification) Complete() bool {
+	return v.Pieces > 0 && v.Good == v.Pieces
+}
+
+func (v *Verification) Completion() float64 {
+	if v.Pieces == 0 {
+		return 0
+	}
+	return float64(v.Good) / float64(v.Pieces) * 100
+}
+
+// Where the files of info are stored for a download saved as saveDir/saveFile. Files Vuze linked elsewhere use their link.
+func DataFilePaths(info *TorrentInfo, saveDir string, saveFile string, links map[int]string) []string {
+	paths := make([]string, len(info.Files))
+	for i, f := range info.Files {
+		if link, ok := links[i]; ok && link != "" {
+			paths[i] = link
+			continue
+		}
+		rel := strings.TrimPrefix(f.Path, info.Name)
+		paths[i] = filepath.Join(saveDir, saveFile, rel)
+	}
+	return paths
+}
+
+// A piece is read from the files it spans, from offset in the first one
+type pieceSpan struct {
+	file   int
+	offset int64
+	length int64
+}
+
+// Hashes every piece of info read from paths with a pool of workers. v2 only torrents have no v1 pieces and are not supported.
+func VerifyPieces(ctx context.Context, info *TorrentInfo, paths []string, workers int) (*Verification, error) {
+	pieces := info.PieceCount()
+	if pieces == 0 {
+		return nil, errors.New("torrent has no v1 piece hashes, v2 only torrents can not be verified")
+	}
+	if info.PieceLength <= 0 {
+		return nil, errors.New("torrent has no piece length")
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	v := &Verification{Pieces: pieces, States: make([]byte, pieces)}
+	files, missing := openDataFiles(info, paths)
+	defer closeDataFiles(files)
+	v.Missing = missing
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, info.PieceLength)
+			for piece := range jobs {
+				if verifyPiece(info, files, piece, buf) {
+					v.States[piece] = PieceDone
+				}
+			}
+		}()
+	}
+	for piece := 0; piece < pieces; piece++ {
+		select {
+		case jobs <- piece:
+		case <-ctx.Done():
+			close(jobs)
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for piece, state := range v.States {
+		if state == PieceDone {
+			v.Good++
+		} else {
+			v.BadPieces = append(v.BadPieces, piece)
+		}
+	}
+	return v, nil
+}
+
+// Opens every file of info that is not padding, files that can not be opened are nil and listed as missing like short files
+func openDataFiles(info *TorrentInfo, paths []string) (files []*os.File, missing []string) {
+	files = make([]*os.File, len(info.Files))
+	for i, f := range info.Files {
+		if f.Padding {
+			continue
+		}
+		file, err := os.Open(paths[i])
+		if err != nil {
+			missing = append(missing, paths[i])
+			continue
+		}
+		if stat, err := file.Stat(); err == nil && stat.Size() < f.Length {
+			missing = append(missing, fmt.Sprintf("%s (%d of %d bytes)", paths[i], stat.Size(), f.Length))
+		}
+		files[i] = file
+	}
+	return files, missing
+}
+
+func closeDataFiles(files []*os.File) {
+	for _, file := range files {
+		if file != nil {
+			file.Close()
+		}
+	}
+}
+
+// Hashes up to samples pieces spread over file of info, preferring pieces that lie entirely inside it so the other files
+// do not have to be present. Returns how many of the hashed pieces are good.
+func VerifyFileSample(info *TorrentInfo, paths []string, file int, samples int) (good int, checked int) {
+	if info.PieceCount() == 0 || info.PieceLength <= 0 || samples < 1 {
+		return 0, 0
+	}
+	start := int64(0)
+	for _, f := range info.Files[:file] {
+		start += f.Length
+	}
+	end := start + info.Files[file].Length
+	first := int((start + info.PieceLength - 1) / info.PieceLength)
+	last := int(end/info.PieceLength) - 1
+	if end == info.TotalLength() {
+		last = info.PieceCount() - 1
+	}
+	if last < first {
+		// Smaller than a piece, the pieces overlapping it need the files around it
+		first, last = int(start/info.PieceLength), int((end-1)/info.PieceLength)
+	}
+
+	files, _ := openDataFiles(info, paths)
+	defer closeDataFiles(files)
+	buf := make([]byte, info.PieceLength)
+	count := last - first + 1
+	if samples > count {
+		samples = count
+	}
+	for i := 0; i < samples; i++ {
+		piece := first
+		if samples > 1 {
+			piece = first + i*(count-1)/(samples-1)
+		}
+		checked++
+		if verifyPiece(info, files, piece, buf) {
+			good++
+		}
+	}
+	return good, checked
+}
+
+func verifyPiece(info *TorrentInfo, files []*os.File, piece int, buf []byte) bool {
+	start := int64(piece) * info.PieceLength
+	length := info.PieceLength
+	if total := info.TotalLength(); start+length > total {
+		length = total - start
+	}
+	data := buf[:length]
+	read := int64(0)
+	for _, span := range pieceSpans(info, start, length) {
+		chunk := data[read : read+span.length]
+		if info.Files[span.file].Padding {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		} else if files[span.file] == nil {
+			return false
+		} else if _, err := files[span.file].ReadAt(chunk, span.offset); err != nil {
+			// Short files return io.EOF, their pieces are not done
+			return false
+		}
+		read += span.length
+	}
+	sum := sha1.Sum(data)
+	return bytes.Equal(sum[:], info.PieceHash(piece))
+}
+
+// The parts of the files covering length bytes from start of the concatenated files
+func pieceSpans(info *TorrentInfo, start int64, length int64) (spans []pieceSpan) {
+	fileStart := int64(0)
+	for i, f := range info.Files {
+		fileEnd := fileStart + f.Length
+		if fileEnd > start && length > 0 {
+			offset := start - fileStart
+			n := f.Length - offset
+			if n > length {
+				n = length
+			}
+			spans = append(spans, pieceSpan{file: i, offset: offset, length: n})
+			start += n
+			length -= n
+		}
+		fileStart = fileEnd
+	}
+	return spans
+}
+
+// Replaces the piece states in the resume data of an active file and marks it valid, so Vuze does not recheck the download.
+// Partial pieces are dropped as every piece is now known to be done or not, everything else is kept byte for byte.
+func SetResumePieceStates(data []byte, states []byte) ([]byte, error) {
+	resume := map[string]interface{}{}
+	if raw, err := utils.BencodeDictValue(data, "resume"); err == nil {
+		if decoded, err := utils.BencodeUnmarshal(raw); err == nil && bencodeMap(decoded) != nil {
+			resume = bencodeMap(decoded)
+		}
+	}
+	resumeData := bencodeMap(resume["data"])
+	if resumeData == nil {
+		resumeData = map[string]interface{}{}
+	}
+	resumeData["resume data"] = states
+	resumeData["valid"] = int64(1)
+	resumeData["blocks"] = map[string]interface{}{}
+	resume["data"] = resumeData
+
+	encoded, err := bencode.EncodeBytes(resume)
+	if err != nil {
+		return nil, err
+	}
+	return utils.BencodeSetDictValue(data, "resume", encoded)
+}
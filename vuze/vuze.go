@@ -1,13 +1,11 @@
 package vuze
 
 import (
-	"errors"
-	"github.com/IncSW/go-bencode"
+	"encoding/hex"
+	"fmt"
 	"github.com/KyleBanks/go-kit/log"
-	"github.com/blaize9/vuze-tools/config"
 	"github.com/blaize9/vuze-tools/utils"
 	"github.com/djherbis/times"
-	pbar "github.com/pmalek/pb"
 	"io"
 	"io/ioutil"
 	"math/rand"
@@ -19,53 +17,41 @@ import (
 	"time"
 )
 
-func CheckHashStorage() (storage HashStorage, lastMod time.Time, BackupDirCount int, UniqueHashCount int) {
-	err := utils.LoadStruct(filepath.Join(config.GetAzRecoverPath(), "hashstorage.struct"), &storage)
+// Builds a torrent from the torrent stored in an active .dat file
+func TorrentFromActive(ActivePath string) ([]byte, error) {
+	dat, err := LoadActiveDat(ActivePath)
 	if err != nil {
-		log.Errorf("Error reading hashstorage [%s]", err)
+		return nil, err
 	}
-	lastMod = storage.LastModified
-	BackupDirCount = len(storage.BackupDirectories)
-	UniqueHashCount = len(storage.HashMap)
-
-	log.Infof("HashStorage was last modified %s and contains %d directories with %d unique hashes", lastMod, BackupDirCount, UniqueHashCount)
-	return
-}
-
-func ReadDownloadsConfig() (interface{}, error) {
-	file, er := ioutil.ReadFile(config.GetAzDownloadsConfig())
-	if er != nil {
-		return nil, errors.New("Unable to open vuze downloads config")
+	torrent, err := dat.Torrent()
+	if err != nil {
+		return nil, err
 	}
-	data, err := bencode.Unmarshal(file)
+	hashes, err := utils.GetInfoHashes(torrent)
 	if err != nil {
-		return nil, errors.New("Unable to unmarshal vuze downloads config")
+		return nil, err
 	}
-	return data, nil
+	if err := checkActiveHash(ActivePath, hashes); err != nil {
+		return nil, err
+	}
+	return torrent, nil
 }
 
-func SaveTorrentFromActive(ActivePath string, destFilepath string) (bool, error) {
-	file, err := ioutil.ReadFile(ActivePath)
-	if err != nil {
-		return false, err
+// The active file is named after the v1 info hash of its torrent, or the truncated v2 hash for v2 only torrents
+func checkActiveHash(ActivePath string, hashes utils.InfoHashes) error {
+	expected, err := hex.DecodeString(strings.SplitN(filepath.Base(ActivePath), ".", 2)[0])
+	if err != nil || !hashes.Matches(expected) {
+		return fmt.Errorf("torrent info hash %s does not match active file %s", hashes, filepath.Base(ActivePath))
 	}
-	data, err := bencode.Unmarshal(file)
+	return nil
+}
+
+func SaveTorrentFromActive(ActivePath string, destFilepath string) (bool, error) {
+	m, err := TorrentFromActive(ActivePath)
 	if err != nil {
 		return false, err
 	}
 
-	datam := data.(map[string]interface{})
-	bencodeM := map[string]interface{}{}
-
-	for k, v := range datam {
-		if k == "comment" || k == "created by" || k == "creation date" || k == "encoding" ||
-			k == "info" || k == "announce" || k == "announce-list" {
-			bencodeM[k] = v
-		}
-	}
-
-	m, _ := bencode.Marshal(bencodeM)
-
 	destFile, err := os.Create(destFilepath)
 	if err != nil {
 		return false, err
@@ -94,7 +80,17 @@ func SaveTorrentFromActive(ActivePath string, destFilepath string) (bool, error)
 		return false, err
 	}
 
-	return true, err
+	// Make sure what ended up on disk is the torrent the active file belongs to
+	hashes, err := utils.GetInfoHashesFromFile(destFilepath)
+	if err == nil {
+		err = checkActiveHash(ActivePath, hashes)
+	}
+	if err != nil {
+		os.Remove(destFilepath)
+		return false, err
+	}
+
+	return true, nil
 }
 
 // Directories inside Path must contain ####-##-##
@@ -114,103 +110,46 @@ func GetAllVuzeBackupDirectores(Path string) (dirs []string) {
 	return dirs
 }
 
-func ProcessActiveDirectory(activePath string) map[string]VuzeDat {
-	Hashes := map[string]VuzeDat{}
-
-	datFileCount := 0
+// Hashes of the downloads with at least one variant of their .dat in activePath, sorted so plans and reports
+// of different runs can be compared. Nothing is parsed, LoadDatVariants reads the variants.
+func ActiveHashes(activePath string) (hashes []string) {
 	files, _ := ioutil.ReadDir(activePath)
+	seen := map[string]bool{}
 	for _, finfo := range files {
-		if filepath.Ext(finfo.Name()) == ".dat" {
-			datFileCount++
+		if finfo.IsDir() {
+			continue
 		}
-	}
-
-	bar := pbar.StartNew(datFileCount)
-	for _, finfo := range files {
-		ActivePath := config.GetAzActivePath() + "/" + finfo.Name()
-
-		hash := strings.TrimSuffix(ActivePath, filepath.Ext(ActivePath))
-		baseFilename := filepath.Base(ActivePath)
-		baseFilenameWithoutExt := strings.TrimSuffix(baseFilename, filepath.Ext(baseFilename))
-		if filepath.Ext(ActivePath) == ".dat" {
-			vuzeDat := VuzeDat{}
-
-			AZ := hash + ".dat._AZ"
-			BAK := hash + ".dat.bak"
-			SAVING := hash + ".dat.saving"
-
-			if utils.IsBencodeFileValid(ActivePath) {
-				vuzeDat.IsDatValid = true
-			}
-			if utils.FileExists(AZ) {
-				vuzeDat.HasAZ = true
-				if utils.IsBencodeFileValid(AZ) {
-					vuzeDat.IsAZValid = true
-				}
-			}
-			if utils.FileExists(BAK) {
-				vuzeDat.HasBak = true
-				if utils.IsBencodeFileValid(BAK) {
-					vuzeDat.IsBakValid = true
-				}
+		for _, suffix := range activeDatVariants {
+			hash := strings.TrimSuffix(finfo.Name(), suffix)
+			if hash != finfo.Name() && hash != "" && !seen[hash] {
+				seen[hash] = true
+				hashes = append(hashes, hash)
 			}
-			if utils.FileExists(SAVING) {
-				vuzeDat.HasSaving = true
-				if utils.IsBencodeFileValid(SAVING) {
-					vuzeDat.IsSavingValid = true
-				}
-			}
-
-			Hashes[baseFilenameWithoutExt] = vuzeDat
-			bar.Increment()
 		}
-
 	}
-
-	bar.FinishPrint("Finished Scanning. Start the recovery!")
-	return Hashes
+	sort.Strings(hashes)
+	return hashes
 }
 
-func ScanDownloadsConfig() ([]TorrentPathHash, error) {
-	data, err := ReadDownloadsConfig()
+// Checks the torrent of every download of the downloads.config at path
+func ScanDownloadsConfig(path string) ([]TorrentPathHash, error) {
+	dc, err := LoadDownloadsConfig(path)
 	if err != nil {
 		log.Errorf("%v", err)
 		return nil, err
 	}
-	datam := data.(map[string]interface{})
 	torrents := []TorrentPathHash{}
-	if utils.IsMap(datam) {
-		for k, v := range datam {
-			if k == "downloads" {
-				for _, vv := range v.([]interface{}) {
-					torrent := TorrentPathHash{}
-					for kkk, vvv := range vv.(map[string]interface{}) {
-						if kkk == "torrent" {
-							//files++
-							torrent_filepath := utils.ByteToString(vvv.([]uint8))
-							if torrent_filepath != "" {
-								torrent.Filepath = torrent_filepath
-								if utils.FileExists(torrent_filepath) {
-									torrent.Found = true
-									if utils.IsTorrentValid(torrent_filepath) == nil {
-										torrent.Valid = true
-									}
-								}
-							}
-
-						}
-
-						if kkk == "torrent_hash" {
-							torrent.Hash = vvv.([]uint8)
-						}
-					}
-					torrents = append(torrents, torrent)
-				}
+	for _, download := range dc.Downloads {
+		torrent := TorrentPathHash{Filepath: download.Torrent, Hash: download.TorrentHash}
+		if torrent.Filepath != "" && utils.FileExists(torrent.Filepath) {
+			torrent.Found = true
+			if utils.IsTorrentValid(torrent.Filepath) == nil {
+				torrent.Valid = true
 			}
 		}
-		return torrents, nil
+		torrents = append(torrents, torrent)
 	}
-	return torrents, errors.New("downloads.config is not valid!")
+	return torrents, nil
 }
 
 func ShuffleBackupDirectories(slice []string) {
@@ -1,16 +1,17 @@
 package vuze
 
 import (
-	"fmt"
+	"context"
+	"encoding/hex"
+	"errors"
 	"github.com/blaize9/vuze-tools/config"
 	"github.com/blaize9/vuze-tools/utils"
 	"github.com/blaize9/vuze-tools/utils/log"
-	"github.com/djherbis/times"
-	torrentParser "github.com/j-muller/go-torrent-parser"
-	"io/ioutil"
+	"os"
 	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -49,123 +50,148 @@ func (f FilepathSlice) Less(i, j int) bool {
 	return f[i].DateModified.After(f[j].DateModified)
 }
 
-func BackupHashFinder(vuzeBackupDirectories *[]string) HashStorage {
-	var HashStorage = HashStorage{BackupDirectories: *vuzeBackupDirectories}
-	HashStoragePath := filepath.Join(config.GetAzRecoverPath(), "hashstorage.struct")
-	var ResumeHashStorage bool
-	var NewBackupDirectories []string
-	if utils.FileExists(HashStoragePath) {
-		fmt.Printf("Checking existing hashstorage.struct\n")
-		FileHashStorage, _, hashStorageDirCount, _ := CheckHashStorage()
-		fmt.Printf("Current Backup Dirs: %d\nHashStorage File Dirs: %d\n", len(*vuzeBackupDirectories), hashStorageDirCount)
-
-		for _, dir := range *vuzeBackupDirectories {
-			if !utils.SliceContains(FileHashStorage.BackupDirectories, dir) {
-				NewBackupDirectories = append(NewBackupDirectories, dir)
-				fmt.Printf("%s was not found in HashStorage file\n", dir)
-			}
-		}
-		fmt.Println()
-
-		if utils.AskForconfirmation("Would you like to load hashstorage.struct?") {
-			if len(*vuzeBackupDirectories) != len(FileHashStorage.BackupDirectories) {
-				if utils.AskForconfirmation("Would you like to scan new directories?") {
-					ResumeHashStorage = true
-					HashStorage = FileHashStorage
-				} else {
-					return FileHashStorage
-				}
-			} else {
-				return FileHashStorage
-			}
-		} else {
-			HashStorage.BackupDirectories = *vuzeBackupDirectories
-		}
-	}
+// A backup torrent file waiting to be indexed
+type indexJob struct {
+	path string
+	file os.FileInfo
+}
 
-	var wg sync.WaitGroup
-	var BackupDirectories []string
-	if ResumeHashStorage {
-		BackupDirectories = NewBackupDirectories
-		HashStorage.BackupDirectories = append(HashStorage.BackupDirectories, NewBackupDirectories...)
-		HashStorage.BackupDirectories = utils.UniqueStringSlice(HashStorage.BackupDirectories)
-	} else {
-		BackupDirectories = *vuzeBackupDirectories
+// Indexes the torrents of every backup directory with a pool of advanced_recovery_max_workers workers.
+// When ctx is cancelled the progress so far is saved and ctx.Err() is returned.
+func BackupHashFinder(ctx context.Context, vuzeBackupDirectories *[]string) (HashStorage, error) {
+	index, err := LoadHashIndex(GetHashIndexPath())
+	if err != nil {
+		return HashStorage{}, err
 	}
+	log.Infof("Hash index was last modified %s and contains %d files", index.LastModified, len(index.Files))
 
-	wg.Add(len(BackupDirectories))
+	workers := config.Get().AdvancedRecoverMaxWorkers
+	if workers < 1 {
+		workers = 1
+	}
 	start := time.Now()
-	var mutex = &sync.Mutex{}
+	var scanned, parsed int64
+	jobs := make(chan indexJob, workers*4)
 
-	var hashMap = make(map[string]FilepathSlice)
-	workers := 0
-	for _, bkdir := range BackupDirectories {
-		for workers > config.Get().AdvancedRecoverMaxWorkers {
-			time.Sleep(time.Second * 25)
-		}
-		workers++
-		go func(bkdir string) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
 			defer wg.Done()
-			torrentDir := filepath.Join(bkdir, config.Get().AzureusTorrentsDirectory)
-			if utils.DirExists(torrentDir) {
-				files, _ := ioutil.ReadDir(torrentDir + "/")
-				for _, tfile := range files {
-					if filepath.Ext(tfile.Name()) == ".torrent" {
-						tfilepath := filepath.Join(torrentDir, tfile.Name())
-						torrent, err := torrentParser.ParseFromFile(tfilepath)
-						if err != nil {
-							continue
-						}
-						ftime, _ := times.Stat(tfilepath)
-						mutex.Lock()
-						hashMap[torrent.InfoHash] = append(hashMap[torrent.InfoHash], Filepath{Filepath: tfilepath, DateModified: ftime.ModTime()})
-						mutex.Unlock()
-					}
+			for job := range jobs {
+				if index.IndexFile(job.path, job.file) {
+					atomic.AddInt64(&parsed, 1)
 				}
-				log.Infof("[W%s] Finished scanning %s (%d files)\n", time.Since(start), torrentDir, len(files))
+				atomic.AddInt64(&scanned, 1)
 			}
-			workers--
-		}(bkdir)
+		}()
 	}
+
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(time.Second * 10)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				logIndexThroughput(start, atomic.LoadInt64(&scanned), atomic.LoadInt64(&parsed))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+feed:
+	for _, bkdir := range *vuzeBackupDirectories {
+		torrentDir := filepath.Join(bkdir, config.Get().AzureusTorrentsDirectory)
+		if !utils.DirExists(torrentDir) {
+			continue
+		}
+		files, err := listTorrentFiles(torrentDir)
+		if err != nil {
+			log.Errorf("Error scanning %s [%s]", torrentDir, err)
+			continue
+		}
+		for _, file := range files {
+			select {
+			case jobs <- indexJob{path: filepath.Join(torrentDir, file.Name()), file: file}:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		index.Prune(torrentDir, files)
+		log.Debugf("Queued %s (%d files)", torrentDir, len(files))
+	}
+	close(jobs)
 	wg.Wait()
-	HashStorage.LastModified = time.Now()
-	HashStorage.HashMap = hashMap
-	err := utils.SaveStruct(HashStoragePath, HashStorage)
-	if err != nil {
-		log.Errorf("Error saving hashstorage [%s]", err)
+	close(done)
+	logIndexThroughput(start, scanned, parsed)
+
+	if config.Get().DryRun {
+		log.Infof("Dry run, not saving hash index to %s", GetHashIndexPath())
+	} else if err := index.Save(); err != nil {
+		log.Errorf("Error saving hash index [%s]", err)
+	}
+	if ctx.Err() != nil {
+		log.Warnf("Scan interrupted, progress saved to %s", GetHashIndexPath())
+		return HashStorage{}, ctx.Err()
 	}
 
 	log.Infof("Total time taken to scan %s", time.Since(start).String())
-	return HashStorage
+	return index.HashStorage(*vuzeBackupDirectories), nil
 }
 
-func TorrentFinderWorker(worker int, recovered chan<- int, unrecovered chan<- int, torrentFiles <-chan string, finished chan<- bool, chFilesCompleted chan<- int, recoveredMap chan<- RecoveredTorrent, vuzeBackupDirectories *[]string) {
-	log.Infof("Worker %d started", worker)
+func logIndexThroughput(start time.Time, scanned int64, parsed int64) {
+	elapsed := time.Since(start)
+	log.Infof("Indexed %d files (%d new or changed) in %s, %.0f files/s", scanned, parsed, elapsed.Truncate(time.Millisecond), float64(scanned)/elapsed.Seconds())
+}
+
+// Reasons Simple Recovery could not recover a torrent
+var (
+	ErrTorrentNotFound = errors.New("not found in backups")
+	ErrHashMismatch    = errors.New("found in backups but the info hash does not match downloads.config")
+)
 
-	//defer wg.Done()
+// Looks for every torrent of torrentFiles in the backup directories and sends exactly one result per torrent,
+// with Err set when it could not be recovered
+func TorrentFinderWorker(worker int, torrentFiles <-chan TorrentPathHash, results chan<- RecoveredTorrent, vuzeBackupDirectories *[]string) {
+	log.Infof("Worker %d started", worker)
 
-	for torrentFilepath := range torrentFiles {
-		tfilepath := torrentFilepath
+	for torrent := range torrentFiles {
+		tfilepath := torrent.Filepath
 		log.Debugf("Worker %d Working on %s\n", worker, tfilepath)
 		filename := filepath.Base(tfilepath)
 		var foundTorrentFile bool
-		if !utils.FileExists(torrentFilepath) {
+		mismatches := 0
+		if !utils.FileExists(tfilepath) {
 			for _, bkdir := range *vuzeBackupDirectories {
 				findTorrent := filepath.Join(bkdir, config.Get().AzureusTorrentsDirectory, filename)
-				if utils.FileExists(findTorrent) && utils.IsTorrentValid(findTorrent) == nil {
-					log.Debugf("[%d] %s FOUND\n", worker, findTorrent)
-					recoveredMap <- RecoveredTorrent{Filename: filename, OrigFilepath: torrentFilepath, BackupFilepath: findTorrent}
-					recovered <- 1
-					foundTorrentFile = true
-					break
+				if !utils.FileExists(findTorrent) || utils.IsTorrentValid(findTorrent) != nil {
+					continue
+				}
+				// Without a torrent_hash in downloads.config the filename is all we can match on
+				if len(torrent.Hash) > 0 {
+					hashes, err := utils.GetInfoHashesFromFile(findTorrent)
+					if err != nil || !hashes.Matches(torrent.Hash) {
+						log.Warnf("[W%d] %s info hash %s does not match %s", worker, findTorrent, hashes, hex.EncodeToString(torrent.Hash))
+						mismatches++
+						continue
+					}
 				}
+				log.Debugf("[%d] %s FOUND\n", worker, findTorrent)
+				results <- RecoveredTorrent{Filename: filename, OrigFilepath: tfilepath, BackupFilepath: findTorrent}
+				foundTorrentFile = true
+				break
 			}
 		}
 		if foundTorrentFile == false {
-			log.Warnf("[W%d] %s NOT FOUND", worker, tfilepath)
-			unrecovered <- 1
+			err := ErrTorrentNotFound
+			if mismatches > 0 {
+				err = ErrHashMismatch
+			}
+			log.Warnf("[W%d] %s %v", worker, tfilepath, err)
+			results <- RecoveredTorrent{Filename: filename, OrigFilepath: tfilepath, Err: err}
 		}
-		chFilesCompleted <- 1
 	}
 	log.Infof("Worker %d Closed", worker)
 }